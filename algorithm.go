@@ -0,0 +1,227 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/alphadose/haxmap"
+)
+
+// ErrUnsupportedAlgorithm is returned by the operations that only support
+// AlgoGCRA, listed in WithAlgorithm, for keys using another algorithm.
+var ErrUnsupportedAlgorithm = errors.New("rate_limiter: operation not supported by the key's algorithm")
+
+// Algorithm selects how a key's limit is enforced.
+type Algorithm int
+
+const (
+	// AlgoGCRA enforces the limit with the generic cell rate algorithm,
+	// allowing bursts of up to Burst events. This is the default.
+	AlgoGCRA Algorithm = iota
+	// AlgoFixedWindow allows Rate events per Period, counted in windows
+	// starting with the first event, see also WithAlignedWindows. It is the
+	// cheapest algorithm but allows up to twice the rate across a window
+	// boundary.
+	AlgoFixedWindow
+	// AlgoSlidingLog allows Rate events in any Period by recording every
+	// event. It is exact but stores one entry per allowed event.
+	AlgoSlidingLog
+	// AlgoSlidingWindow approximates AlgoSlidingLog by counting events in
+	// buckets, see WithWindowBuckets. The bucket partly outside the window
+	// is weighted by its overlap.
+	AlgoSlidingWindow
+	// AlgoSteppedRefill allows Burst events per Period and restores the
+	// full burst at once at every multiple of Period since the Unix epoch,
+	// for example on the hour, instead of refilling continuously. Rate is
+	// not used.
+	AlgoSteppedRefill
+)
+
+type algorithmScripts struct {
+	allowN *script
+	peek   *script
+	refund *script
+}
+
+var algorithms = map[Algorithm]algorithmScripts{
+	AlgoGCRA:          {allowN: allowN, peek: peek, refund: refund},
+	AlgoFixedWindow:   {allowN: fixedWindowAllowN, peek: fixedWindowPeek, refund: fixedWindowRefund},
+	AlgoSlidingLog:    {allowN: slidingLogAllowN, peek: slidingLogPeek, refund: slidingLogRefund},
+	AlgoSlidingWindow: {allowN: slidingWindowAllowN, peek: slidingWindowPeek, refund: slidingWindowRefund},
+	AlgoSteppedRefill: {allowN: steppedRefillAllowN, peek: steppedRefillPeek, refund: steppedRefillRefund},
+}
+
+func (a Algorithm) scripts() algorithmScripts {
+	if s, ok := algorithms[a]; ok {
+		return s
+	}
+	return algorithms[AlgoGCRA]
+}
+
+// WithWindowBuckets sets how many buckets AlgoSlidingWindow divides a period
+// into. More buckets enforce the limit more tightly near the edge of the
+// window at the cost of storing more counters per key. The default is 1,
+// which weighs the previous period against the current one. It panics if n
+// is less than 1.
+func WithWindowBuckets(n int) LimiterOption {
+	if n < 1 {
+		panic("rate_limiter: window buckets must be at least 1")
+	}
+	return func(l *Limiter) {
+		l.windowBuckets = strconv.Itoa(n)
+	}
+}
+
+// WithAlignedWindows makes AlgoFixedWindow start windows at multiples of the
+// period since the Unix epoch, such as on the minute for per-minute limits,
+// instead of at a key's first event. ResetAfter then points to the next
+// boundary. Other algorithms are not affected.
+func WithAlignedWindows() LimiterOption {
+	return func(l *Limiter) {
+		l.alignedWindows = true
+	}
+}
+
+// algorithmArgs returns the script arguments specific to algorithm, passed
+// after the common ones.
+func (l Limiter) algorithmArgs(algorithm Algorithm) []string {
+	switch {
+	case algorithm == AlgoSlidingWindow:
+		return []string{l.windowBuckets}
+	case algorithm == AlgoFixedWindow && l.alignedWindows:
+		return []string{"1"}
+	case algorithm == AlgoGCRA && l.lazyTTL > 0:
+		return []string{strconv.FormatInt(l.lazyTTL.Milliseconds(), 10)}
+	}
+	return nil
+}
+
+// KeyPolicy is the configuration applied to a key.
+type KeyPolicy struct {
+	Limit
+	Algorithm Algorithm
+}
+
+// capacity returns the most events that can ever be allowed at once.
+func (p KeyPolicy) capacity() int {
+	if p.Algorithm == AlgoGCRA || p.Algorithm == AlgoSteppedRefill {
+		return p.Burst
+	}
+	return p.Rate
+}
+
+// WithAlgorithm sets the algorithm used for keys without a KeyPolicy.
+// AllowAtMost, AllowIfRemaining, Charge, RefillBurst, RampReset,
+// TransferQuota, ScheduleAt, ReserveAt and AllowHierarchy only support
+// AlgoGCRA and return ErrUnsupportedAlgorithm for keys using another
+// algorithm. Rate, on the contrary, doesn't support AlgoGCRA. SharedBudget
+// always uses AlgoGCRA.
+func WithAlgorithm(algorithm Algorithm) LimiterOption {
+	return func(l *Limiter) {
+		l.algorithm = algorithm
+	}
+}
+
+// WithKeyPolicies sets per-key policies. They take precedence over the
+// custom limits. The map may be updated after the limiter is created.
+func WithKeyPolicies(policies *haxmap.Map[string, KeyPolicy]) LimiterOption {
+	return func(l *Limiter) {
+		l.policies = policies
+	}
+}
+
+// SetPolicy sets the policy for key.
+func (l *Limiter) SetPolicy(key string, policy KeyPolicy) {
+	l.policies.Set(key, policy)
+}
+
+// DeletePolicy removes the policy for key.
+func (l *Limiter) DeletePolicy(key string) {
+	l.policies.Del(key)
+}
+
+// Policy is a KeyPolicy along with the key it applies to, as returned by
+// Policies.
+type Policy struct {
+	// Key is the key the policy applies to, or "" for the default policy.
+	Key string
+	KeyPolicy
+}
+
+// Policies describes every configured policy: the default one first,
+// followed by the key policies and the custom limits sorted by key. A key
+// with both is only reported with its key policy, which takes precedence.
+// Scheduled limits are not included.
+func (l Limiter) Policies() []Policy {
+	byKey := make(map[string]KeyPolicy)
+	l.customLimits.Range(func(key string, limit Limit) bool {
+		byKey[key] = KeyPolicy{Limit: limit, Algorithm: l.algorithm}
+		return true
+	})
+	l.policies.ForEach(func(key string, policy KeyPolicy) bool {
+		byKey[key] = policy
+		return true
+	})
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	policies := make([]Policy, 0, len(keys)+1)
+	policies = append(policies, Policy{KeyPolicy: KeyPolicy{Limit: l.limit, Algorithm: l.algorithm}})
+	for _, key := range keys {
+		policies = append(policies, Policy{Key: key, KeyPolicy: byKey[key]})
+	}
+	return policies
+}
+
+// policyFor returns the policy configured for key, falling back to its
+// limit with the limiter's algorithm.
+func (l Limiter) policyFor(key string) KeyPolicy {
+	if p, ok := l.policies.Get(key); ok {
+		return p
+	}
+	return KeyPolicy{Limit: l.limitFor(key), Algorithm: l.algorithm}
+}
+
+// gcraLimitFor returns the limit of key for operations that only support
+// AlgoGCRA, or ErrUnsupportedAlgorithm if key uses another algorithm.
+func (l Limiter) gcraLimitFor(key string) (Limit, error) {
+	policy := l.policyFor(key)
+	if policy.Algorithm != AlgoGCRA {
+		return Limit{}, ErrUnsupportedAlgorithm
+	}
+	return policy.Limit, nil
+}
+
+// Rate estimates the recent arrival rate for key in events per second from
+// the events counted against its current window. The estimate is exact for
+// AlgoSlidingLog. AlgoGCRA only keeps the time at which a key is full again,
+// which doesn't tell how fast events arrived, so ErrUnsupportedAlgorithm is
+// returned for it.
+func (l Limiter) Rate(ctx context.Context, key string) (float64, error) {
+	if err := l.validateKeys(key); err != nil {
+		return 0, err
+	}
+	policy := l.policyFor(key)
+	if policy.Algorithm == AlgoGCRA {
+		return 0, ErrUnsupportedAlgorithm
+	}
+	res, err := l.Peek(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	used := float64(policy.capacity() - res.Remaining)
+	if used <= 0 {
+		return 0, nil
+	}
+	window := policy.Period
+	if (policy.Algorithm == AlgoFixedWindow || policy.Algorithm == AlgoSteppedRefill) && res.ResetAfter > 0 {
+		window -= res.ResetAfter
+	}
+	return used / max(window, time.Millisecond).Seconds(), nil
+}
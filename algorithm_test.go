@@ -0,0 +1,261 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var windowAlgorithms = []Algorithm{AlgoFixedWindow, AlgoSlidingLog, AlgoSlidingWindow, AlgoSteppedRefill}
+
+func TestWindowAlgorithms(t *testing.T) {
+	for _, algo := range windowAlgorithms {
+		l, _ := newTestLimiter(t, WithAlgorithm(algo), WithRateLimit(Limit{Rate: 3, Burst: 3, Period: time.Hour}))
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			res, err := l.AllowN(ctx, "k", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed != 1 || res.Remaining != 2-i {
+				t.Fatalf("algorithm %d, call %d: allowed %d, remaining %d", algo, i, res.Allowed, res.Remaining)
+			}
+		}
+		res, err := l.AllowN(ctx, "k", 1)
+		if err != nil || res.Reason != ReasonLimitExceeded || res.RetryAfter <= 0 {
+			t.Fatalf("algorithm %d: got %+v, %v, want a denial with a retry time", algo, res, err)
+		}
+	}
+}
+
+func TestRefundWindowAlgorithms(t *testing.T) {
+	for _, algo := range windowAlgorithms {
+		l, _ := newTestLimiter(t, WithAlgorithm(algo), WithRateLimit(Limit{Rate: 3, Burst: 3, Period: time.Hour}))
+		ctx := context.Background()
+		if _, err := l.AllowN(ctx, "k", 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Refund(ctx, "k", 2); err != nil {
+			t.Fatalf("algorithm %d: %v", algo, err)
+		}
+		res, err := l.Peek(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Remaining != 2 {
+			t.Fatalf("algorithm %d: remaining %d after the refund, want 2", algo, res.Remaining)
+		}
+		if err := l.RefundMany(ctx, map[string]int{"k": 5}); err != nil {
+			t.Fatal(err)
+		}
+		if res, _ := l.Peek(ctx, "k"); res.Remaining != 3 {
+			t.Fatalf("algorithm %d: remaining %d after refunding more than used, want 3", algo, res.Remaining)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	for _, algo := range windowAlgorithms {
+		l, mr := newTestLimiter(t, WithAlgorithm(algo), WithRateLimit(PerMinute(60)))
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		mr.SetTime(now)
+		ctx := context.Background()
+		// one event every two seconds for two windows, sampled a second
+		// after the last one
+		for i := 0; i < 60; i++ {
+			step := 2 * time.Second
+			if i == 0 {
+				step = time.Second / 2
+			}
+			now = now.Add(step)
+			mr.SetTime(now)
+			mr.FastForward(step)
+			if _, err := l.Allow(ctx, "k"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		now = now.Add(time.Second)
+		mr.SetTime(now)
+		mr.FastForward(time.Second)
+		rate, err := l.Rate(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate < 0.4 || rate > 0.6 {
+			t.Errorf("algorithm %d: estimated %.3f events per second, want about 0.5", algo, rate)
+		}
+	}
+
+	l, _ := newTestLimiter(t)
+	_, err := l.Rate(context.Background(), "k")
+	checkUnsupported(t, "Rate", err)
+}
+
+func TestGCRAOnlyOperations(t *testing.T) {
+	l, mr := newTestLimiter(t)
+	l.SetPolicy("w", KeyPolicy{Limit: PerMinute(5), Algorithm: AlgoFixedWindow})
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "w"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := mr.Get(l.redisKey("w"))
+
+	_, err := l.Charge(ctx, "w", 1)
+	checkUnsupported(t, "Charge", err)
+	_, err = l.AllowIfRemaining(ctx, "w", 1, 0)
+	checkUnsupported(t, "AllowIfRemaining", err)
+	_, err = l.AllowAtMost(ctx, "w", PerMinute(5), 1)
+	checkUnsupported(t, "AllowAtMost", err)
+	checkUnsupported(t, "RefillBurst", l.RefillBurst(ctx, "w"))
+	checkUnsupported(t, "RampReset", l.RampReset(ctx, "w", time.Minute))
+	checkUnsupported(t, "TransferQuota", l.TransferQuota(ctx, "w", "g"))
+	_, err = l.ScheduleAt(ctx, "w", 1)
+	checkUnsupported(t, "ScheduleAt", err)
+	_, err = l.AllowHierarchy(ctx, []string{"g", "w"}, 1)
+	checkUnsupported(t, "AllowHierarchy", err)
+
+	if after, _ := mr.Get(l.redisKey("w")); after != before {
+		t.Fatalf("stored count changed from %q to %q", before, after)
+	}
+}
+
+func checkUnsupported(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("%s: got %v, want ErrUnsupportedAlgorithm", op, err)
+	}
+}
+
+func TestKeyPolicyLimit(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	l.SetPolicy("k", KeyPolicy{Limit: PerMinute(2), Algorithm: AlgoGCRA})
+	ctx := context.Background()
+	res, err := l.Charge(ctx, "k", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Limit != PerMinute(2) || res.Remaining != 1 {
+		t.Fatalf("got limit %v and %d remaining, want the policy's limit", res.Limit, res.Remaining)
+	}
+	l.SetPolicy("w", KeyPolicy{Limit: PerMinute(4), Algorithm: AlgoSlidingLog})
+	res, err = l.ResetR(ctx, "w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Limit != PerMinute(4) || res.Remaining != 4 {
+		t.Fatalf("got limit %v and %d remaining after the reset, want the policy's", res.Limit, res.Remaining)
+	}
+}
+
+func TestUsedCountsCapacity(t *testing.T) {
+	l, _ := newTestLimiter(t, WithAlgorithm(AlgoFixedWindow), WithRateLimit(Limit{Rate: 5, Burst: 1, Period: time.Hour}))
+	ctx := context.Background()
+	res, err := l.AllowN(ctx, "k", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Used != 2 {
+		t.Fatalf("got %d used, want 2", res.Used)
+	}
+	results, err := l.PeekMany(ctx, []string{"k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Used != 2 {
+		t.Fatalf("got %d used from PeekMany, want 2", results[0].Used)
+	}
+}
+
+func TestKeyPolicies(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	l.SetPolicy("log", KeyPolicy{Limit: PerMinute(2), Algorithm: AlgoSlidingLog})
+	ctx := context.Background()
+	for _, key := range []string{"log", "gcra"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if typ := mr.Type(l.redisKey("log")); typ != "zset" {
+		t.Fatalf("key with a sliding log policy stored as %s", typ)
+	}
+	if typ := mr.Type(l.redisKey("gcra")); typ != "string" {
+		t.Fatalf("key with the default policy stored as %s", typ)
+	}
+	res, err := l.Peek(ctx, "log")
+	if err != nil || res.Limit != PerMinute(2) || res.Remaining != 1 {
+		t.Fatalf("got %+v, %v, want the policy's limit", res, err)
+	}
+
+	policies := l.Policies()
+	if len(policies) != 2 || policies[1].Key != "log" || policies[1].Algorithm != AlgoSlidingLog {
+		t.Fatalf("got policies %+v", policies)
+	}
+	l.DeletePolicy("log")
+	if p := l.policyFor("log"); p.Algorithm != AlgoGCRA || p.Limit != PerMinute(10) {
+		t.Fatalf("got policy %+v after deleting it, want the default", p)
+	}
+}
+
+func TestPerDayWindow(t *testing.T) {
+	l, mr := newTestLimiter(t, WithAlgorithm(AlgoFixedWindow), WithRateLimit(PerDay(3)), WithTTLMargin(time.Hour))
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+		mr.FastForward(d)
+	}
+	advance(0)
+	if res, err := l.AllowN(ctx, "k", 3); err != nil || res.Allowed != 3 {
+		t.Fatalf("got %+v, %v, want the day's events allowed", res, err)
+	}
+
+	advance(24*time.Hour - time.Second)
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v a second before the day ends, want a denial", res, err)
+	}
+	if res.ResetAfter != time.Second {
+		t.Fatalf("got reset after %v, want 1s", res.ResetAfter)
+	}
+	if ttl, err := l.TTL(ctx, "k"); err != nil || ttl != time.Hour+time.Second {
+		t.Fatalf("got TTL %v, %v, want the window's end plus the margin", ttl, err)
+	}
+
+	advance(time.Second)
+	if res, err := l.AllowN(ctx, "k", 3); err != nil || res.Allowed != 3 {
+		t.Fatalf("got %+v, %v once the day ended, want a new window", res, err)
+	}
+}
+
+func TestAlignedWindows(t *testing.T) {
+	l, mr := newTestLimiter(t, WithAlgorithm(AlgoFixedWindow), WithRateLimit(PerMinute(2)), WithAlignedWindows())
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 20, 0, time.UTC)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+		mr.FastForward(d)
+	}
+	advance(0)
+	res, err := l.AllowN(ctx, "k", 2)
+	if err != nil || res.Allowed != 2 {
+		t.Fatalf("got %+v, %v, want the events allowed", res, err)
+	}
+	if res.ResetAfter != 40*time.Second || !res.WindowStart.Equal(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got reset after %v and window start %v, want the window on the minute", res.ResetAfter, res.WindowStart)
+	}
+
+	advance(30 * time.Second)
+	res, err = l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 0 || res.ResetAfter != 10*time.Second {
+		t.Fatalf("got %+v, %v, want a denial until the next minute", res, err)
+	}
+
+	advance(10 * time.Second)
+	res, err = l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 1 || res.ResetAfter != time.Minute {
+		t.Fatalf("got %+v, %v on the minute, want a new window", res, err)
+	}
+}
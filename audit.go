@@ -0,0 +1,50 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+)
+
+// AuditEntry records a single decision made by AllowN or AllowAtMost.
+type AuditEntry struct {
+	// Key is the key the decision was made for, without the prefix.
+	Key string
+	// N is the number of events that were requested.
+	N int
+	// Result is the decision returned to the caller.
+	Result *Result
+	// Time is when the decision was made.
+	Time time.Time
+	// Metadata is what the metadata extractor returned for the call's
+	// context, if one is set.
+	Metadata map[string]string
+}
+
+// WithAuditSink calls sink after every decision made by AllowN and
+// AllowAtMost, including decisions made by the failure mode. sink runs
+// synchronously on the caller's goroutine and should return quickly.
+func WithAuditSink(sink func(ctx context.Context, entry AuditEntry)) LimiterOption {
+	return func(l *Limiter) {
+		l.auditSink = sink
+	}
+}
+
+// WithDeniedHook calls hook for every call that was denied by its limit. The
+// call's context is passed on so that hook can link the denial to the active
+// trace, for example by attaching an exemplar with the span's trace ID to a
+// deny counter.
+func WithDeniedHook(hook func(ctx context.Context, key string, n int, res *Result)) LimiterOption {
+	return func(l *Limiter) {
+		l.deniedHook = hook
+	}
+}
+
+// WithMetadataExtractor attaches the result of extract for the call's context,
+// such as a trace ID or user agent, to every audit entry. When entries are
+// turned into metrics, keep the values low-cardinality: each distinct value
+// becomes a separate series.
+func WithMetadataExtractor(extract func(ctx context.Context) map[string]string) LimiterOption {
+	return func(l *Limiter) {
+		l.metadataExtractor = extract
+	}
+}
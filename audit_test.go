@@ -0,0 +1,74 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAuditSink(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var entries []AuditEntry
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithClock(func() time.Time { return now }),
+		WithAuditSink(func(ctx context.Context, entry AuditEntry) {
+			entries = append(entries, entry)
+		}))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := l.AllowN(ctx, "k", 1); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(entries))
+	}
+	for i, allowed := range []int{1, 0} {
+		e := entries[i]
+		if e.Key != "k" || e.N != 1 || e.Result.Allowed != allowed || !e.Time.Equal(time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC)) {
+			t.Errorf("entry %d: got %+v", i, e)
+		}
+	}
+	if entries[1].Result.Reason != ReasonLimitExceeded {
+		t.Errorf("denied entry has reason %v", entries[1].Result.Reason)
+	}
+}
+
+type traceIDKey struct{}
+
+func TestAuditMetadata(t *testing.T) {
+	var entries []AuditEntry
+	l, _ := newTestLimiter(t,
+		WithAuditSink(func(ctx context.Context, entry AuditEntry) {
+			entries = append(entries, entry)
+		}),
+		WithMetadataExtractor(func(ctx context.Context) map[string]string {
+			id, _ := ctx.Value(traceIDKey{}).(string)
+			return map[string]string{"trace_id": id}
+		}))
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "abc123")
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Metadata["trace_id"] != "abc123" {
+		t.Fatalf("got entries %+v, want the call's trace ID", entries)
+	}
+}
+
+func TestDeniedHookContext(t *testing.T) {
+	var traceIDs []string
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)),
+		WithDeniedHook(func(ctx context.Context, key string, n int, res *Result) {
+			id, _ := ctx.Value(traceIDKey{}).(string)
+			traceIDs = append(traceIDs, id)
+		}))
+	for _, id := range []string{"allowed", "denied"} {
+		ctx := context.WithValue(context.Background(), traceIDKey{}, id)
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(traceIDs) != 1 || traceIDs[0] != "denied" {
+		t.Fatalf("got trace IDs %v, want only the denied call's", traceIDs)
+	}
+}
@@ -0,0 +1,145 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while the circuit breaker is open and the
+// failure mode is FailWithError.
+var ErrCircuitOpen = errors.New("rate_limiter: circuit breaker is open")
+
+// FailureMode decides what the limiter returns when Redis can't be used.
+type FailureMode int
+
+const (
+	// FailWithError returns the error to the caller. This is the default.
+	FailWithError FailureMode = iota
+	// FailOpen allows the events as if the limit had not been reached.
+	FailOpen
+	// FailClosed denies the events as if the limit had been reached.
+	FailClosed
+)
+
+// WithFailureMode sets how AllowN and AllowAtMost behave when Redis returns
+// an error or the circuit breaker is open.
+func WithFailureMode(mode FailureMode) LimiterOption {
+	return func(l *Limiter) {
+		l.failureMode = mode
+	}
+}
+
+// WithCircuitBreaker stops calling Redis for cooldown once failures
+// consecutive calls have failed. After the cooldown a single probe call is
+// let through; the breaker closes again if it succeeds. It panics if
+// failures is less than 1.
+func WithCircuitBreaker(failures int, cooldown time.Duration) LimiterOption {
+	if failures < 1 {
+		panic("rate_limiter: circuit breaker failures must be at least 1")
+	}
+	return func(l *Limiter) {
+		l.breaker = &breaker{threshold: failures, cooldown: cooldown}
+	}
+}
+
+// WithCallFailClosed makes a single call deny its events when Redis can't be
+// reached, whatever the limiter's failure mode.
+func WithCallFailClosed() CallOption {
+	return CallOption{apply: func(s *callSettings) {
+		s.failClosed = true
+	}}
+}
+
+// failResult builds the result for a call that could not reach Redis
+// according to the limiter's failure mode.
+func (l Limiter) failResult(limit Limit, n int, err error) (*Result, error) {
+	switch l.failureMode {
+	case FailOpen:
+		return &Result{
+			Limit:      limit,
+			Allowed:    n,
+			RetryAfter: l.sentinel.value(),
+			ResetAfter: l.sentinel.value(),
+		}, nil
+	case FailClosed:
+		return &Result{
+			Limit:      limit,
+			RetryAfter: l.sentinel.value(),
+			ResetAfter: l.sentinel.value(),
+			Reason:     ReasonUnavailable,
+		}, nil
+	}
+	return nil, err
+}
+
+// extraErr returns err, the error of work done after a call was decided,
+// such as counting denials, unless a failure mode other than FailWithError
+// is set, in which case the decision stands and err is dropped.
+func (l Limiter) extraErr(err error) error {
+	if l.failureMode != FailWithError {
+		return nil
+	}
+	return err
+}
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+	probing  bool
+}
+
+// allow reports whether a call may be sent to Redis. A nil breaker always
+// allows.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of a call. Cancellations by
+// the caller say nothing about Redis and are not counted, but a canceled
+// probe waits for another cooldown before the next one is let through.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		if b.probing {
+			b.probing = false
+			b.openedAt = b.now()
+		}
+		return
+	}
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.open = true
+		b.probing = false
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,123 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerCanceledProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := &breaker{threshold: 1, cooldown: time.Second, now: func() time.Time { return now }}
+	b.record(errors.New("down"))
+	if b.allow() {
+		t.Fatal("open breaker allowed a call before the cooldown")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatal("no probe after the cooldown")
+	}
+	b.record(context.Canceled)
+	if b.allow() {
+		t.Fatal("canceled probe didn't wait for another cooldown")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatal("no probe after a canceled probe")
+	}
+	b.record(nil)
+	if !b.allow() || !b.allow() {
+		t.Fatal("breaker didn't close after a successful probe")
+	}
+}
+
+func TestBreakerFailureModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode    FailureMode
+		allowed int
+		reason  Reason
+	}{
+		{FailOpen, 1, ReasonNone},
+		{FailClosed, 0, ReasonUnavailable},
+	} {
+		l, mr := newTestLimiter(t, WithFailureMode(tc.mode), WithCircuitBreaker(1, time.Minute))
+		mr.SetError("ERR unavailable")
+		for i := 0; i < 2; i++ {
+			res, err := l.Allow(context.Background(), "k")
+			if err != nil {
+				t.Fatalf("mode %d call %d: %v", tc.mode, i, err)
+			}
+			if res.Allowed != tc.allowed || res.Reason != tc.reason {
+				t.Fatalf("mode %d call %d: got %+v", tc.mode, i, res)
+			}
+		}
+	}
+
+	l, mr := newTestLimiter(t, WithCircuitBreaker(1, time.Minute))
+	mr.SetError("ERR unavailable")
+	if _, err := l.Allow(context.Background(), "k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("first call: got %v, want the Redis error", err)
+	}
+	if _, err := l.Allow(context.Background(), "k"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second call: got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCallFailClosed(t *testing.T) {
+	l, mr := newTestLimiter(t, WithFailureMode(FailOpen))
+	mr.SetError("ERR unavailable")
+	ctx := context.Background()
+	res, err := l.AllowN(ctx, "k", 1, WithCallFailClosed())
+	if err != nil || res.Allowed != 0 || res.Reason != ReasonUnavailable {
+		t.Fatalf("got %+v, %v, want the call to fail closed", res, err)
+	}
+	if res, err := l.AllowN(ctx, "k", 1); err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want later calls to fail open", res, err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	l, mr := newTestLimiter(t, WithCircuitBreaker(2, time.Minute), WithFailureMode(FailOpen),
+		WithClock(func() time.Time { return now }))
+	ctx := context.Background()
+	mr.SetError("ERR unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.SetError("")
+	before := mr.CommandCount()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("open breaker let %d commands through", n)
+	}
+
+	now = now.Add(time.Minute)
+	mr.SetError("ERR unavailable")
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetError("")
+	before = mr.CommandCount()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatal("a failed probe didn't reopen the breaker")
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(ctx, "k")
+		if err != nil || !res.fromRedis {
+			t.Fatalf("call %d after a successful probe: got %+v, %v, want a result from Redis", i, res, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package rate_limiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// WithCardinalityTracking counts the distinct keys passed to AllowN in a
+// HyperLogLog per window of the default limit's period, reported by
+// DistinctKeys. Windows follow the limiter's clock. The count is approximate,
+// with a standard error of 0.81%, and costs an extra write per call.
+func WithCardinalityTracking() LimiterOption {
+	return func(l *Limiter) {
+		l.trackCardinality = true
+	}
+}
+
+// distinctKey returns the key of the HyperLogLog counting the keys of the
+// current window.
+func (l Limiter) distinctKey() string {
+	period := max(l.limit.Period, time.Second)
+	window := l.now().UnixNano() / int64(period)
+	return l.prefix + auxMarker + "distinct:" + strconv.FormatInt(window, 10)
+}
+
+// trackKey adds key to the HyperLogLog of the current window. It only runs
+// for calls that reach Redis. Errors are ignored since the count is best
+// effort.
+func (l Limiter) trackKey(ctx context.Context, key string) {
+	if !l.trackCardinality {
+		return
+	}
+	distinctKey := l.distinctKey()
+	// the window ends within a period
+	ttl := max(l.limit.Period, time.Second) + l.ttlMargin
+	l.rdb.DoMulti(ctx,
+		l.rdb.B().Pfadd().Key(distinctKey).Element(key).Build(),
+		l.rdb.B().Pexpire().Key(distinctKey).Milliseconds(ttl.Milliseconds()).Build())
+}
+
+// DistinctKeys returns the approximate number of distinct keys passed to
+// AllowN in the current window. It requires WithCardinalityTracking and
+// returns 0 otherwise.
+func (l Limiter) DistinctKeys(ctx context.Context) (int64, error) {
+	if !l.trackCardinality {
+		return 0, nil
+	}
+	return l.rdb.Do(ctx, l.rdb.B().Pfcount().Key(l.distinctKey()).Build()).AsInt64()
+}
@@ -0,0 +1,31 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDistinctKeys(t *testing.T) {
+	l, _ := newTestLimiter(t, WithCardinalityTracking())
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "a"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := l.DistinctKeys(ctx); err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 distinct keys", n, err)
+	}
+}
+
+func TestDistinctKeysDisabled(t *testing.T) {
+	l, mr := newTestLimiter(t, WithCardinalityTracking())
+	l.SetEnabled(false)
+	before := mr.CommandCount()
+	if _, err := l.Allow(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("disabled limiter sent %d commands", n)
+	}
+}
@@ -0,0 +1,54 @@
+package rate_limiter
+
+import (
+	"sync/atomic"
+
+	"github.com/alphadose/haxmap"
+)
+
+// WithSharedCustomLimits makes Clone share the parent's custom limits and key
+// policies instead of copying them, so that limits set on either limiter
+// apply to both.
+func WithSharedCustomLimits() LimiterOption {
+	return func(l *Limiter) {
+		l.shareCustomLimits = true
+	}
+}
+
+// Clone returns a new Limiter using the same client and settings as l with
+// opts applied on top. The custom limits and key policies are copied unless
+// WithSharedCustomLimits is given or opts replace them. A LimitStore set with
+// WithLimitStore is always shared. The circuit breaker, if any, is shared with
+// l unless opts configure a new one.
+func (l *Limiter) Clone(opts ...LimiterOption) *Limiter {
+	clone := *l
+	clone.shareCustomLimits = false
+	// opts may change how limits are formatted
+	clone.argsCache = &limitArgsCache{}
+	clone.waiters = &waiters{counts: make(map[string]int)}
+	clone.enabled = &atomic.Bool{}
+	clone.enabled.Store(l.enabled.Load())
+	for _, opt := range opts {
+		opt(&clone)
+	}
+
+	clone.setUp(l)
+
+	if _, ok := l.customLimits.(haxmapStore); ok && clone.customLimits == l.customLimits && !clone.shareCustomLimits {
+		clone.customLimits = newHaxmapStore()
+		l.customLimits.Range(func(key string, limit Limit) bool {
+			clone.customLimits.Set(key, limit)
+			return true
+		})
+	}
+
+	if clone.policies == l.policies && !clone.shareCustomLimits {
+		clone.policies = haxmap.New[string, KeyPolicy]()
+		l.policies.ForEach(func(key string, policy KeyPolicy) bool {
+			clone.policies.Set(key, policy)
+			return true
+		})
+	}
+
+	return &clone
+}
@@ -0,0 +1,27 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCloneNilStores(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	l.SetLimit("k", PerMinute(3))
+	clone := l.Clone(WithCustomLimits(nil), WithKeyPolicies(nil))
+	clone.SetLimit("c", PerMinute(2))
+	clone.SetPolicy("p", KeyPolicy{Limit: PerMinute(4), Algorithm: AlgoFixedWindow})
+	ctx := context.Background()
+	for key, want := range map[string]Limit{"k": PerMinute(5), "c": PerMinute(2), "p": PerMinute(4)} {
+		res, err := clone.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Limit != want {
+			t.Fatalf("key %q: got limit %v, want %v", key, res.Limit, want)
+		}
+	}
+	if res, _ := l.Allow(ctx, "c"); res.Limit != PerMinute(5) {
+		t.Fatalf("got limit %v on the parent, want the default", res.Limit)
+	}
+}
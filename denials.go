@@ -0,0 +1,40 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+)
+
+// WithConsecutiveDenials counts how many times in a row each key was denied
+// by AllowN, AllowAtMost and Charge, reported as Result.ConsecutiveDenials.
+// The count is reset by the next allowed call. It is stored next to the key
+// and costs an extra round trip per call.
+func WithConsecutiveDenials() LimiterOption {
+	return func(l *Limiter) {
+		l.countDenials = true
+	}
+}
+
+// withDenials updates the consecutive denials of key according to res when
+// WithConsecutiveDenials is enabled. Results that didn't come from Redis,
+// such as those of the failure mode, leave the count as it is.
+func (l Limiter) withDenials(ctx context.Context, key string, res *Result, err error) (*Result, error) {
+	if !l.countDenials || err != nil || res == nil || !res.fromRedis {
+		return res, err
+	}
+	denialsKey := l.auxKey("denials", key)
+	if res.Allowed > 0 || res.Rejected == 0 {
+		return res, l.extraErr(l.rdb.Do(ctx, l.rdb.B().Del().Key(denialsKey).Build()).Error())
+	}
+	// the count expires along with the key's state
+	ttl := max(res.Limit.Period, time.Second) + l.ttlMarginFor(res.Limit)
+	resps := l.rdb.DoMulti(ctx,
+		l.rdb.B().Incr().Key(denialsKey).Build(),
+		l.rdb.B().Pexpire().Key(denialsKey).Milliseconds(ttl.Milliseconds()).Build())
+	n, err := resps[0].AsInt64()
+	if err != nil {
+		return res, l.extraErr(err)
+	}
+	res.ConsecutiveDenials = int(n)
+	return res, nil
+}
@@ -0,0 +1,41 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConsecutiveDenials(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithConsecutiveDenials())
+	ctx := context.Background()
+	for i, want := range []int{0, 1, 2} {
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ConsecutiveDenials != want {
+			t.Fatalf("call %d: %d consecutive denials, want %d", i, res.ConsecutiveDenials, want)
+		}
+	}
+}
+
+func TestConsecutiveDenialsFailOpen(t *testing.T) {
+	l, mr := newTestLimiter(t, WithConsecutiveDenials(), WithFailureMode(FailOpen))
+	mr.SetError("ERR unavailable")
+	res, err := l.Allow(context.Background(), "k")
+	if err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the failure mode's result", res, err)
+	}
+}
+
+func TestConsecutiveDenialsDisabled(t *testing.T) {
+	l, mr := newTestLimiter(t, WithConsecutiveDenials())
+	l.SetEnabled(false)
+	before := mr.CommandCount()
+	if _, err := l.Allow(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("disabled limiter sent %d commands", n)
+	}
+}
@@ -0,0 +1,84 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// KeyState is the stored state of a key as returned by Export.
+type KeyState struct {
+	// Key is the key without the prefix.
+	Key string
+	// Limit is the limit currently resolved for the key. It is informative
+	// only and is not written by Import.
+	Limit Limit
+	// Value is the DUMP serialization of the key's Redis value.
+	Value []byte
+	// TTL is the time left until the key expires, or 0 if it doesn't.
+	TTL time.Duration
+}
+
+// Export returns the state of every key under the limiter's prefix so that
+// it can be moved with Import to another Redis of the same or a newer
+// version. Keys that expire while exporting are left out.
+func (l Limiter) Export(ctx context.Context) ([]KeyState, error) {
+	var states []KeyState
+	err := l.scan(ctx, "*", func(keys []string) error {
+		cmds := make(rueidis.Commands, 0, 2*len(keys))
+		for _, k := range keys {
+			cmds = append(cmds,
+				l.rdb.B().Dump().Key(k).Build(),
+				l.rdb.B().Pttl().Key(k).Build())
+		}
+		resps := l.rdb.DoMulti(ctx, cmds...)
+		for i, k := range keys {
+			value, err := resps[2*i].AsBytes()
+			if rueidis.IsRedisNil(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			ttl, err := resps[2*i+1].AsInt64()
+			if err != nil {
+				return err
+			}
+			key := l.trimKey(k)
+			states = append(states, KeyState{
+				Key:   key,
+				Limit: l.policyFor(key).Limit,
+				Value: value,
+				TTL:   time.Duration(max(ttl, 0)) * time.Millisecond,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Import writes states returned by Export, replacing any existing keys.
+func (l Limiter) Import(ctx context.Context, states []KeyState) error {
+	for len(states) > 0 {
+		batch := states[:min(len(states), scanCount)]
+		states = states[len(batch):]
+		cmds := make(rueidis.Commands, len(batch))
+		for i, s := range batch {
+			if err := l.validateKeys(s.Key); err != nil {
+				return err
+			}
+			cmds[i] = l.rdb.B().Restore().Key(l.redisKey(s.Key)).Ttl(s.TTL.Milliseconds()).
+				SerializedValue(string(s.Value)).Replace().Build()
+		}
+		for _, resp := range l.rdb.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package rate_limiter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// fakeDump registers DUMP and RESTORE, which miniredis lacks, serializing
+// string keys as their value.
+func fakeDump(mr *miniredis.Miniredis) {
+	mr.Server().Register("DUMP", func(c *server.Peer, cmd string, args []string) {
+		v, err := mr.Get(args[0])
+		if err != nil {
+			c.WriteNull()
+			return
+		}
+		c.WriteBulk(v)
+	})
+	mr.Server().Register("RESTORE", func(c *server.Peer, cmd string, args []string) {
+		ms, _ := strconv.Atoi(args[1])
+		mr.Set(args[0], args[2])
+		if ms > 0 {
+			mr.SetTTL(args[0], time.Duration(ms)*time.Millisecond)
+		}
+		c.WriteOK()
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	from, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	to, mr2 := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range []*miniredis.Miniredis{mr, mr2} {
+		fakeDump(m)
+		m.SetTime(now)
+	}
+	for key, n := range map[string]int{"a": 3, "b": 7} {
+		if _, err := from.AllowN(ctx, key, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states, err := from.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("got %d states, want 2", len(states))
+	}
+	if err := to.Import(ctx, states); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		want, err := from.Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := to.Peek(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Remaining != want.Remaining || got.ResetAfter != want.ResetAfter {
+			t.Fatalf("%s: got %+v after importing, want %+v", key, got, want)
+		}
+	}
+	if ttl := mr2.TTL(to.redisKey("b")); ttl != mr.TTL(from.redisKey("b")) {
+		t.Fatalf("got TTL %v after importing, want %v", ttl, mr.TTL(from.redisKey("b")))
+	}
+}
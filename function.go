@@ -0,0 +1,130 @@
+package rate_limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/redis/rueidis"
+)
+
+const libraryName = "rate_limiter"
+
+var (
+	// registered holds every script so that they can be loaded together as
+	// a function library.
+	registered []*script
+
+	libraryOnce sync.Once
+	libraryCode string
+)
+
+// library returns the code of a function library registering every script
+// as a function named after its SHA1, so that changed scripts never collide
+// with functions loaded by older versions.
+func library() string {
+	libraryOnce.Do(func() {
+		var b strings.Builder
+		b.WriteString("#!lua name=" + libraryName + "\n")
+		for _, s := range registered {
+			// KEYS and ARGV are passed as parameters so that the script
+			// bodies work unchanged. Functions always replicate effects and
+			// don't provide redis.replicate_commands.
+			b.WriteString("redis.register_function('" + s.function() + "', function(KEYS, ARGV)\n")
+			b.WriteString(strings.ReplaceAll(s.src, "redis.replicate_commands()\n", ""))
+			b.WriteString("\nend)\n")
+		}
+		libraryCode = b.String()
+	})
+	return libraryCode
+}
+
+func (s *script) function() string {
+	return "rl_" + s.sha
+}
+
+type functionMode struct {
+	// unsupported is set once the server turned out not to support
+	// functions, after which scripts are run with EVAL.
+	unsupported atomic.Bool
+}
+
+// WithFunctionMode runs the scripts as a Redis function library with FCALL
+// instead of EVALSHA. The library is loaded with FUNCTION LOAD the first time
+// it is missing. Servers older than Redis 7 fall back to EVAL.
+func WithFunctionMode() LimiterOption {
+	return func(l *Limiter) {
+		l.functions = &functionMode{}
+	}
+}
+
+func (f *functionMode) enabled() bool {
+	return f != nil && !f.unsupported.Load()
+}
+
+// isUnknownCommand reports whether err is the server rejecting a command it
+// doesn't know. rueidis strips the "ERR " prefix of most errors.
+func isUnknownCommand(err error) bool {
+	rerr, ok := rueidis.IsRedisErr(err)
+	return ok && strings.HasPrefix(strings.TrimPrefix(rerr.Error(), "ERR "), "unknown command")
+}
+
+func isFunctionNotFound(err error) bool {
+	rerr, ok := rueidis.IsRedisErr(err)
+	return ok && strings.Contains(rerr.Error(), "Function not found")
+}
+
+func (l Limiter) fcallCmd(s *script, keys, args []string) rueidis.Completed {
+	return l.rdb.B().Fcall().Function(s.function()).Numkeys(int64(len(keys))).Key(keys...).Arg(args...).Build()
+}
+
+// loadLibrary loads the function library on every primary. It reports false
+// if the server doesn't support functions.
+func (l Limiter) loadLibrary(ctx context.Context) (bool, error) {
+	for _, node := range l.rdb.Nodes() {
+		replica, err := isReplica(ctx, node)
+		if err != nil {
+			return false, err
+		}
+		if replica {
+			continue
+		}
+		err = node.Do(ctx, node.B().FunctionLoad().Replace().FunctionCode(library()).Build()).Error()
+		if isUnknownCommand(err) {
+			l.functions.unsupported.Store(true)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	l.stats.reload(libraryName)
+	l.stats.markLoaded(libraryName)
+	return true, nil
+}
+
+// fcall runs s as a function, loading the library if needed. It reports
+// false if functions are not supported and s must be run with EVAL instead.
+func (l Limiter) fcall(ctx context.Context, s *script, keys, args []string) (rueidis.RedisResult, bool) {
+	resp := l.rdb.Do(ctx, l.fcallCmd(s, keys, args))
+	err := resp.Error()
+	if isUnknownCommand(err) {
+		l.functions.unsupported.Store(true)
+		return resp, false
+	}
+	if err == nil {
+		l.stats.markLoaded(libraryName)
+	}
+	if !isFunctionNotFound(err) {
+		return resp, true
+	}
+	ok, err := l.loadLibrary(ctx)
+	if err != nil {
+		return resp, true
+	}
+	if !ok {
+		return resp, false
+	}
+	return l.rdb.Do(ctx, l.fcallCmd(s, keys, args)), true
+}
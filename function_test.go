@@ -0,0 +1,46 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFunctionModeFallback(t *testing.T) {
+	// miniredis has no FCALL, like servers older than Redis 7
+	l, _ := newTestLimiter(t, WithFunctionMode(), WithRateLimit(PerMinute(2)))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if res.Allowed != 1 || res.Remaining != 1-i {
+			t.Fatalf("call %d: got %+v", i, res)
+		}
+	}
+	if !l.functions.unsupported.Load() {
+		t.Fatal("function mode didn't fall back to EVAL")
+	}
+	if _, err := l.PeekMany(ctx, []string{"k", "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RefundMany(ctx, map[string]int{"k": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := l.Peek(ctx, "k"); err != nil || res.Remaining != 1 {
+		t.Fatalf("got %+v, %v after the refund", res, err)
+	}
+}
+
+func TestIsUnknownCommand(t *testing.T) {
+	l, mr := newTestLimiter(t)
+	err := l.rdb.Do(context.Background(), l.rdb.B().Arbitrary("NOSUCHCOMMAND").Build()).Error()
+	if !isUnknownCommand(err) {
+		t.Fatalf("%v not recognized as an unknown command", err)
+	}
+	mr.SetError("ERR unavailable")
+	err = l.rdb.Do(context.Background(), l.rdb.B().Ping().Build()).Error()
+	if isUnknownCommand(err) {
+		t.Fatalf("%v recognized as an unknown command", err)
+	}
+}
@@ -0,0 +1,94 @@
+package rate_limiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// WithResetGeneration tracks a generation per key that increases every time
+// Reset or ResetR is called, reported as Result.Generation so that callers
+// can tell whether a key was reset between two of their calls. Generations
+// are the Redis server time of the reset, so they keep increasing when a key
+// expires and is reset again. The generation is stored next to the key and
+// expires along with the key's state. Reading it costs an extra round trip
+// per call.
+func WithResetGeneration() LimiterOption {
+	return func(l *Limiter) {
+		l.generations = true
+	}
+}
+
+func (l Limiter) generationKey(key string) string {
+	return l.auxKey("generation", key)
+}
+
+// generationTTL returns how long the generation of a key with limit is kept
+// after it was last read or bumped, which outlives the key's state.
+func (l Limiter) generationTTL(limit Limit) time.Duration {
+	return max(limit.Period, time.Second) + l.ttlMarginFor(limit)
+}
+
+// generation returns the reset generation of key, extending its expiry
+// since the key is in use. Keys that were not reset are in generation 0.
+func (l Limiter) generation(ctx context.Context, key string, limit Limit) (int64, error) {
+	generationKey := l.generationKey(key)
+	resps := l.rdb.DoMulti(ctx,
+		l.rdb.B().Get().Key(generationKey).Build(),
+		l.rdb.B().Pexpire().Key(generationKey).Milliseconds(l.generationTTL(limit).Milliseconds()).Build())
+	gen, err := resps[0].AsInt64()
+	if rueidis.IsRedisNil(err) {
+		return 0, nil
+	}
+	return gen, err
+}
+
+// withGeneration sets the generation of res when WithResetGeneration is
+// enabled. Results that didn't come from Redis, such as those of the failure
+// mode, are left without one.
+func (l Limiter) withGeneration(ctx context.Context, key string, res *Result, err error) (*Result, error) {
+	if !l.generations || err != nil || res == nil || !res.fromRedis {
+		return res, err
+	}
+	if res.Generation, err = l.generation(ctx, key, res.Limit); err != nil {
+		return res, l.extraErr(err)
+	}
+	return res, nil
+}
+
+// reset deletes the state of key and returns its new generation.
+func (l Limiter) reset(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	del := l.rdb.B().Del().Key(l.redisKey(key)).Build()
+	return l.resetExtras(ctx, key, del)
+}
+
+// resetExtras runs cmds along with the bookkeeping of a reset of key, such as
+// deleting its sequence and bumping its generation, and returns the new
+// generation.
+func (l Limiter) resetExtras(ctx context.Context, key string, cmds ...rueidis.Completed) (int64, error) {
+	// the other keys are deleted separately since they may live in other
+	// cluster slots
+	if l.sequences {
+		cmds = append(cmds, l.rdb.B().Del().Key(l.sequenceKey(key)).Build())
+	}
+	if l.penalty != nil {
+		cmds = append(cmds, l.rdb.B().Del().Key(l.penaltyKey(key)).Build())
+	}
+	if len(cmds) > 0 {
+		for _, resp := range l.rdb.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if !l.generations {
+		return 0, nil
+	}
+	ttl := strconv.FormatInt(l.generationTTL(l.policyFor(key).Limit).Milliseconds(), 10)
+	return l.eval(ctx, bumpGeneration, []string{l.generationKey(key)}, []string{ttl}).AsInt64()
+}
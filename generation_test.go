@@ -0,0 +1,54 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResetGeneration(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(5)), WithResetGeneration())
+	ctx := context.Background()
+	res, err := l.Allow(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Generation != 0 {
+		t.Fatalf("generation %d before any reset", res.Generation)
+	}
+	var last int64
+	for i := 0; i < 2; i++ {
+		res, err := l.ResetR(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Generation <= last {
+			t.Fatalf("reset %d: generation %d after %d", i, res.Generation, last)
+		}
+		last = res.Generation
+	}
+	res, err = l.Allow(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Generation != last {
+		t.Fatalf("generation %d, want %d", res.Generation, last)
+	}
+
+	if ttl := mr.TTL(l.generationKey("k")); ttl <= 0 || ttl > time.Minute+time.Second {
+		t.Fatalf("generation key TTL %s", ttl)
+	}
+	mr.FastForward(2 * time.Minute)
+	if mr.Exists(l.generationKey("k")) {
+		t.Fatal("generation key outlived the key's state")
+	}
+}
+
+func TestResetGenerationFailOpen(t *testing.T) {
+	l, mr := newTestLimiter(t, WithResetGeneration(), WithFailureMode(FailOpen))
+	mr.SetError("ERR unavailable")
+	res, err := l.Allow(context.Background(), "k")
+	if err != nil || res == nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the failure mode's result", res, err)
+	}
+}
@@ -2,9 +2,18 @@ module github.com/jsjain/go-rate-limiter
 
 go 1.22
 
-require github.com/redis/rueidis v1.0.44
+require (
+	github.com/alicebob/miniredis/v2 v2.35.0
+	github.com/redis/rueidis v1.0.44
+	golang.org/x/time v0.9.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117
+	google.golang.org/protobuf v1.34.1
+)
 
-require golang.org/x/exp v0.0.0-20221031165847-c99f073a8326 // indirect
+require (
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/exp v0.0.0-20221031165847-c99f073a8326 // indirect
+)
 
 require (
 	github.com/alphadose/haxmap v1.4.0
@@ -0,0 +1,32 @@
+// Package grpc converts rate limiter results into the standard google.rpc
+// error details attached to gRPC statuses.
+package grpc
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	rl "github.com/jsjain/go-rate-limiter"
+)
+
+// ToRetryInfo returns a RetryInfo telling the client to retry after
+// res.RetryAfter. The delay is left unset if res doesn't have a retry time.
+func ToRetryInfo(res *rl.Result) *errdetails.RetryInfo {
+	info := &errdetails.RetryInfo{}
+	if res.RetryAfter > 0 {
+		info.RetryDelay = durationpb.New(res.RetryAfter)
+	}
+	return info
+}
+
+// ToQuotaFailure returns a QuotaFailure with a single violation describing
+// the limit of res. Its subject is the key that denied the request for
+// results covering several keys.
+func ToQuotaFailure(res *rl.Result) *errdetails.QuotaFailure {
+	return &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     res.DeniedKey,
+			Description: res.LimitString(),
+		}},
+	}
+}
@@ -0,0 +1,29 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	rl "github.com/jsjain/go-rate-limiter"
+)
+
+func TestToRetryInfo(t *testing.T) {
+	info := ToRetryInfo(&rl.Result{RetryAfter: 1500 * time.Millisecond})
+	if d := info.GetRetryDelay().AsDuration(); d != 1500*time.Millisecond {
+		t.Fatalf("got retry delay %v, want 1.5s", d)
+	}
+	if info := ToRetryInfo(&rl.Result{RetryAfter: -1}); info.RetryDelay != nil {
+		t.Fatalf("got retry delay %v for an allowed result, want none", info.RetryDelay)
+	}
+}
+
+func TestToQuotaFailure(t *testing.T) {
+	res := &rl.Result{Limit: rl.PerMinute(10), DeniedKey: "user:1"}
+	v := ToQuotaFailure(res).GetViolations()
+	if len(v) != 1 {
+		t.Fatalf("got %d violations, want 1", len(v))
+	}
+	if v[0].Subject != "user:1" || v[0].Description != "10 req/m (burst 10)" {
+		t.Fatalf("got violation %v, want one describing the limit of user:1", v[0])
+	}
+}
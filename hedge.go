@@ -0,0 +1,82 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+)
+
+// WithHedging makes read-only operations (Peek, TTL and Exists) send a second
+// request if the first hasn't completed within delay, using whichever
+// succeeds first and cancelling the other. Consuming operations are never
+// hedged. Configure the client to send read-only commands to replicas to
+// spread hedged requests across them.
+func WithHedging(delay time.Duration) LimiterOption {
+	return func(l *Limiter) {
+		l.hedgeDelay = delay
+	}
+}
+
+type hedgeResult[T any] struct {
+	v   T
+	err error
+}
+
+// hedge calls fn and, if it hasn't returned after delay, calls it again
+// concurrently. The first successful result is returned, or the last error
+// if both fail.
+func hedge[T any](ctx context.Context, delay time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	if delay <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan hedgeResult[T], 2)
+	run := func() {
+		v, err := fn(ctx)
+		ch <- hedgeResult[T]{v: v, err: err}
+	}
+	go run()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-t.C:
+		go run()
+	}
+
+	r := <-ch
+	if r.err == nil {
+		return r.v, nil
+	}
+	r = <-ch
+	return r.v, r.err
+}
+
+// TTL returns the time until the state of key expires. It returns 0 if key
+// doesn't exist.
+func (l Limiter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := l.validateKeys(key); err != nil {
+		return 0, err
+	}
+	return hedge(ctx, l.hedgeDelay, func(ctx context.Context) (time.Duration, error) {
+		ms, err := l.rdb.Do(ctx, l.rdb.B().Pttl().Key(l.redisKey(key)).Build()).AsInt64()
+		if err != nil || ms < 0 {
+			return 0, err
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	})
+}
+
+// Exists reports whether any state is stored for key.
+func (l Limiter) Exists(ctx context.Context, key string) (bool, error) {
+	if err := l.validateKeys(key); err != nil {
+		return false, err
+	}
+	return hedge(ctx, l.hedgeDelay, func(ctx context.Context) (bool, error) {
+		n, err := l.rdb.Do(ctx, l.rdb.B().Exists().Key(l.redisKey(key)).Build()).AsInt64()
+		return n > 0, err
+	})
+}
@@ -0,0 +1,54 @@
+package rate_limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	var calls atomic.Int32
+	cancelled := make(chan struct{})
+	v, err := hedge(context.Background(), 10*time.Millisecond, func(ctx context.Context) (int, error) {
+		if calls.Add(1) == 1 {
+			// the slow replica only returns once its request is cancelled
+			<-ctx.Done()
+			close(cancelled)
+			return 1, ctx.Err()
+		}
+		return 2, nil
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("got %d, %v, want the hedged response", v, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request wasn't cancelled")
+	}
+
+	calls.Store(0)
+	v, err = hedge(context.Background(), time.Second, func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		return 3, nil
+	})
+	if err != nil || v != 3 || calls.Load() != 1 {
+		t.Fatalf("got %d, %v after %d calls, want a single fast response", v, err, calls.Load())
+	}
+}
+
+func TestHedgingReads(t *testing.T) {
+	l, mr := newTestLimiter(t, WithHedging(time.Second))
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	before := mr.CommandCount()
+	if ok, err := l.Exists(ctx, "k"); err != nil || !ok {
+		t.Fatalf("got %v, %v, want the key to exist", ok, err)
+	}
+	if n := mr.CommandCount() - before; n != 1 {
+		t.Fatalf("fast read sent %d commands, want 1", n)
+	}
+}
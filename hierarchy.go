@@ -0,0 +1,103 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrNoKeys is returned when a multi-key call is given no keys.
+var ErrNoKeys = errors.New("rate_limiter: no keys given")
+
+// TierResult is the state of one of the keys of an AllowHierarchy call.
+type TierResult struct {
+	// Key is the key, without the prefix.
+	Key string
+	// Limit is the limit of the key.
+	Limit Limit
+	// Remaining is the number of events the key has left after the call.
+	Remaining int
+	// ResetAfter is the time until the key returns to its initial state.
+	ResetAfter time.Duration
+}
+
+// AllowHierarchy atomically reports whether n events may happen at time now
+// for every key, such as an organization, team and user, ordered from the
+// broadest to the narrowest. The events are consumed from all keys or, if any
+// key denies them, from none. The Result describes the key with the least
+// remaining or the key that denied the events, which is also reported as
+// Result.DeniedKey, and the hooks and the audit sink are given that key. The
+// state of every key is reported in Result.Tiers. Each key uses its own
+// limit. All keys must hash to the same Redis Cluster slot, for example by
+// sharing a hash tag, or ErrCrossSlot is returned.
+func (l Limiter) AllowHierarchy(ctx context.Context, keys []string, n int) (*Result, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	if err := l.validateKeys(keys...); err != nil {
+		return nil, err
+	}
+	limits := make([]Limit, len(keys))
+	redisKeys := make([]string, len(keys))
+	args := make([]string, 0, 3*len(keys))
+	for i, key := range keys {
+		limit, err := l.gcraLimitFor(key)
+		if err != nil {
+			return nil, err
+		}
+		limits[i] = limit
+		redisKeys[i] = l.redisKey(key)
+	}
+	if err := checkSlots(redisKeys); err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		if limits[i].Burst == 0 {
+			res := l.blockedResult(limits[i])
+			res.Rejected = n
+			res.DeniedKey = key
+			return res, nil
+		}
+		args = append(args,
+			strconv.Itoa(limits[i].Burst),
+			strconv.Itoa(limits[i].Rate),
+			l.formatPeriod(limits[i].Period))
+	}
+	res, err := l.consume(ctx, call{
+		script:    hierarchyAllowN,
+		key:       keys[len(keys)-1],
+		keys:      redisKeys,
+		limit:     limits[len(keys)-1],
+		n:         n,
+		args:      args,
+		algorithm: AlgoGCRA,
+		decided: func(c *call, res *Result) {
+			if res.level < 1 || res.level > len(keys) {
+				return
+			}
+			c.key = keys[res.level-1]
+			c.limit = limits[res.level-1]
+			res.Limit = c.limit
+		},
+	})
+	if err != nil || res.level < 1 || res.level > len(keys) {
+		return res, err
+	}
+	if len(res.tiers) == 2*len(keys) {
+		res.Tiers = make([]TierResult, len(keys))
+		for i, key := range keys {
+			res.Tiers[i] = TierResult{
+				Key:        key,
+				Limit:      limits[i],
+				Remaining:  max(int(res.tiers[2*i]), 0),
+				ResetAfter: time.Duration(res.tiers[2*i+1] * float64(time.Second)),
+			}
+		}
+	}
+	res.tiers = nil
+	if res.Reason == ReasonLimitExceeded {
+		res.DeniedKey = keys[res.level-1]
+	}
+	return res, nil
+}
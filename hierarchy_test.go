@@ -0,0 +1,121 @@
+package rate_limiter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllowHierarchyDecidingKey(t *testing.T) {
+	var denied, audited []string
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(10)),
+		WithDeniedHook(func(ctx context.Context, key string, n int, res *Result) {
+			denied = append(denied, key)
+		}),
+		WithAuditSink(func(ctx context.Context, entry AuditEntry) {
+			audited = append(audited, entry.Key)
+		}))
+	l.SetLimit("{t}org", PerMinute(2))
+	keys := []string{"{t}org", "{t}team", "{t}user"}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		res, err := l.AllowHierarchy(ctx, keys, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Limit != PerMinute(2) || res.Used != min(i+1, 2) {
+			t.Fatalf("call %d: got limit %v and %d used, want the organization's", i, res.Limit, res.Used)
+		}
+	}
+	if len(denied) != 1 || denied[0] != "{t}org" {
+		t.Fatalf("denied hook got keys %q, want the organization", denied)
+	}
+	for i, key := range audited {
+		if key != "{t}org" {
+			t.Fatalf("audit entry %d has key %q, want the organization", i, key)
+		}
+	}
+}
+
+func TestAllowHierarchyMiddleDenies(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	l.SetLimit("{t}team", PerMinute(1))
+	keys := []string{"{t}org", "{t}team", "{t}user"}
+	ctx := context.Background()
+	if _, err := l.AllowHierarchy(ctx, keys, 1); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.AllowHierarchy(ctx, keys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed != 0 || res.DeniedKey != "{t}team" {
+		t.Fatalf("got %+v, want a denial by the team", res)
+	}
+	for _, tier := range res.Tiers {
+		if want := tier.Limit.Burst - 1; tier.Remaining != want {
+			t.Fatalf("tier %q has %d remaining, want %d: the denial consumed events", tier.Key, tier.Remaining, want)
+		}
+	}
+}
+
+func TestAllowHierarchyConcurrent(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerHour(10)))
+	l.SetLimit("{t}org", PerHour(50))
+	ctx := context.Background()
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := l.AllowHierarchy(ctx, []string{"{t}org", user}, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				allowed.Add(int64(res.Allowed))
+			}
+		}("{t}user" + strconv.Itoa(i))
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 50 {
+		t.Fatalf("allowed %d events, want the organization's 50", n)
+	}
+}
+
+func TestAllowHierarchyTiers(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	l.SetLimit("{t}org", PerMinute(100))
+	keys := []string{"{t}org", "{t}user"}
+	ctx := context.Background()
+	if _, err := l.AllowN(ctx, "{t}user", 4); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.AllowHierarchy(ctx, keys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TierResult{
+		{Key: "{t}org", Limit: PerMinute(100), Remaining: 98, ResetAfter: 1200 * time.Millisecond},
+		{Key: "{t}user", Limit: PerMinute(10), Remaining: 4, ResetAfter: 36 * time.Second},
+	}
+	if len(res.Tiers) != len(want) {
+		t.Fatalf("got tiers %+v, want %+v", res.Tiers, want)
+	}
+	for i, tier := range res.Tiers {
+		d := tier.ResetAfter - want[i].ResetAfter
+		tier.ResetAfter = want[i].ResetAfter
+		if tier != want[i] || d < -time.Millisecond || d > time.Millisecond {
+			t.Fatalf("got tiers %+v, want %+v", res.Tiers, want)
+		}
+	}
+	if res.Limit != PerMinute(10) || res.Remaining != 4 {
+		t.Fatalf("got %+v, want the most restrictive tier", res)
+	}
+}
@@ -0,0 +1,148 @@
+package rate_limiter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// KeyEncoding controls how keys are encoded before being stored in Redis.
+type KeyEncoding int
+
+const (
+	// KeyEncodingRaw stores keys as they are. This is the default. Keys
+	// starting with a NUL byte are stored with a second one, since a single
+	// one marks the limiter's own state, see auxKey.
+	KeyEncodingRaw KeyEncoding = iota
+	// KeyEncodingHex stores keys hex encoded.
+	KeyEncodingHex
+	// KeyEncodingBase64 stores keys in unpadded URL-safe base64.
+	KeyEncodingBase64
+)
+
+// WithKeyEncoding sets the encoding applied to keys. The prefix is kept as is
+// so that keys can still be found by prefix.
+func WithKeyEncoding(encoding KeyEncoding) LimiterOption {
+	return func(l *Limiter) {
+		l.keyEncoding = encoding
+	}
+}
+
+func (e KeyEncoding) encode(key string) string {
+	switch e {
+	case KeyEncodingHex:
+		return hex.EncodeToString([]byte(key))
+	case KeyEncodingBase64:
+		return base64.RawURLEncoding.EncodeToString([]byte(key))
+	}
+	if strings.HasPrefix(key, auxMarker) {
+		return auxMarker + key
+	}
+	return key
+}
+
+func (e KeyEncoding) decode(key string) (string, error) {
+	switch e {
+	case KeyEncodingHex:
+		b, err := hex.DecodeString(key)
+		return string(b), err
+	case KeyEncodingBase64:
+		b, err := base64.RawURLEncoding.DecodeString(key)
+		return string(b), err
+	}
+	return strings.TrimPrefix(key, auxMarker), nil
+}
+
+// WithKeyValidator sets a function that checks every key given to the
+// limiter, for example to reject overly long keys or control characters. An
+// operation fails with the returned error before reaching Redis.
+func WithKeyValidator(validate func(key string) error) LimiterOption {
+	return func(l *Limiter) {
+		l.keyValidator = validate
+	}
+}
+
+func (l Limiter) validateKeys(keys ...string) error {
+	if l.keyValidator == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := l.keyValidator(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyForIP returns a key for the network of ip, masked to v4Bits for IPv4
+// addresses and v6Bits for IPv6 ones, so that nearby addresses share a limit.
+// Keys are in CIDR notation, such as "192.0.2.0/24". Prefix lengths are
+// clamped to the size of the address. It returns "" for an invalid ip.
+func KeyForIP(ip net.IP, v4Bits, v6Bits int) string {
+	bits, size := v6Bits, 8*net.IPv6len
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, size = v4, v4Bits, 8*net.IPv4len
+	} else if len(ip) != net.IPv6len {
+		return ""
+	}
+	bits = min(max(bits, 0), size)
+	return ip.Mask(net.CIDRMask(bits, size)).String() + "/" + strconv.Itoa(bits)
+}
+
+// redisKey returns the Redis key used to store the state of key.
+func (l Limiter) redisKey(key string) string {
+	return l.prefix + l.keyEncoding.encode(key)
+}
+
+// auxMarker follows the prefix in the Redis keys holding state kept next to a
+// key, such as its denial count. No encoded key starts with it.
+const auxMarker = "\x00"
+
+// auxKey returns the Redis key holding the state named kind, such as
+// "denials", kept next to key. Auxiliary keys can't collide with the keys of
+// the limiter and are left out of Keys, Count and Export.
+func (l Limiter) auxKey(kind, key string) string {
+	return l.prefix + auxMarker + kind + ":" + l.keyEncoding.encode(key)
+}
+
+// taggedAuxKey is like auxKey but puts the encoded key in a hash tag, so that
+// the auxiliary keys of key used by a single script share a Redis Cluster
+// slot.
+func (l Limiter) taggedAuxKey(kind, key string) string {
+	return l.prefix + auxMarker + kind + ":{" + l.keyEncoding.encode(key) + "}"
+}
+
+// isAuxKey reports whether redisKey was returned by auxKey.
+func (l Limiter) isAuxKey(redisKey string) bool {
+	aux := l.prefix + auxMarker
+	return strings.HasPrefix(redisKey, aux) && !strings.HasPrefix(redisKey, aux+auxMarker)
+}
+
+// trimKey returns the key stored as redisKey, decoding it when possible.
+func (l Limiter) trimKey(redisKey string) string {
+	key := strings.TrimPrefix(redisKey, l.prefix)
+	if decoded, err := l.keyEncoding.decode(key); err == nil {
+		return decoded
+	}
+	return key
+}
+
+// Keys returns every key stored under the limiter's prefix, without the
+// prefix. Keys are decoded with the limiter's key encoding; keys that can't
+// be decoded are returned as stored.
+func (l Limiter) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := l.scan(ctx, "*", func(batch []string) error {
+		for _, k := range batch {
+			keys = append(keys, l.trimKey(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,108 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAuxKeysDontCollide(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithPenalty(Limit{}, time.Minute),
+		WithResetGeneration(), WithConsecutiveDenials(), WithSequence())
+	ctx := context.Background()
+	for _, key := range []string{"alice:penalty", "alice:penalty", "x:generation", "x:generation"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := l.Allow(ctx, "alice")
+	if err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want alice allowed with its own limit", res, err)
+	}
+	if err := l.Reset(ctx, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := l.Allow(ctx, "x"); err != nil || res.Generation == 0 {
+		t.Fatalf("got %+v, %v, want a generation", res, err)
+	}
+}
+
+func TestKeysLeaveOutAuxKeys(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithPenalty(PerMinute(1), time.Minute),
+		WithResetGeneration(), WithConsecutiveDenials(), WithSequence(), WithCardinalityTracking())
+	ctx := context.Background()
+	for _, key := range []string{"a", "a", "\x00b"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Reset(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := l.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if want := []string{"\x00b", "a"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got keys %q, want %q", keys, want)
+	}
+	if n, err := l.Count(ctx); err != nil || n != 2 {
+		t.Fatalf("got count %d, %v, want 2", n, err)
+	}
+	if n, err := l.ResetAll(ctx); err != nil || n != 2 {
+		t.Fatalf("got %d deleted, %v, want 2", n, err)
+	}
+	if n, _ := l.DistinctKeys(ctx); n != 0 {
+		t.Fatalf("got %d distinct keys after ResetAll, want 0", n)
+	}
+}
+
+func TestKeyValidator(t *testing.T) {
+	errTooLong := errors.New("key too long")
+	l, mr := newTestLimiter(t, WithKeyValidator(func(key string) error {
+		if len(key) > 8 {
+			return errTooLong
+		}
+		return nil
+	}))
+	ctx := context.Background()
+	before := mr.CommandCount()
+	if _, err := l.Allow(ctx, "much-too-long"); !errors.Is(err, errTooLong) {
+		t.Fatalf("got %v, want the validator's error", err)
+	}
+	if _, err := l.Peek(ctx, "much-too-long"); !errors.Is(err, errTooLong) {
+		t.Fatalf("got %v from Peek, want the validator's error", err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("invalid keys sent %d commands", n)
+	}
+	if res, err := l.Allow(ctx, "short"); err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want a valid key allowed", res, err)
+	}
+}
+
+func TestKeyForIP(t *testing.T) {
+	key := func(ip string) string {
+		return KeyForIP(net.ParseIP(ip), 24, 48)
+	}
+	if a, b := key("192.0.2.10"), key("192.0.2.200"); a != b || a != "192.0.2.0/24" {
+		t.Fatalf("got %q and %q, want the same /24", a, b)
+	}
+	if a, b := key("192.0.2.10"), key("192.0.3.10"); a == b {
+		t.Fatalf("got %q for different /24s", a)
+	}
+	if a, b := key("2001:db8:1:2::1"), key("2001:db8:1:ffff::2"); a != b || a != "2001:db8:1::/48" {
+		t.Fatalf("got %q and %q, want the same /48", a, b)
+	}
+	if a, b := key("2001:db8:1::1"), key("2001:db8:2::1"); a == b {
+		t.Fatalf("got %q for different /48s", a)
+	}
+	if got := KeyForIP(net.IP{1, 2}, 24, 48); got != "" {
+		t.Fatalf("got %q for an invalid IP, want \"\"", got)
+	}
+}
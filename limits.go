@@ -0,0 +1,79 @@
+package rate_limiter
+
+import "github.com/alphadose/haxmap"
+
+// LimitStore holds the custom limits of a limiter. It must be safe for
+// concurrent use.
+type LimitStore interface {
+	Get(key string) (Limit, bool)
+	Set(key string, limit Limit)
+	Delete(key string)
+	// Range calls fn for every key and its limit until fn returns false.
+	Range(fn func(key string, limit Limit) bool)
+}
+
+// WithLimitStore sets the store holding the custom limits, replacing the
+// default in-memory map.
+func WithLimitStore(store LimitStore) LimiterOption {
+	return func(l *Limiter) {
+		l.customLimits = store
+	}
+}
+
+// haxmapStore is the default LimitStore.
+type haxmapStore struct {
+	m *haxmap.Map[string, Limit]
+}
+
+func newHaxmapStore() haxmapStore {
+	return haxmapStore{m: haxmap.New[string, Limit]()}
+}
+
+func (s haxmapStore) Get(key string) (Limit, bool) {
+	return s.m.Get(key)
+}
+
+func (s haxmapStore) Set(key string, limit Limit) {
+	s.m.Set(key, limit)
+}
+
+func (s haxmapStore) Delete(key string) {
+	s.m.Del(key)
+}
+
+func (s haxmapStore) Range(fn func(key string, limit Limit) bool) {
+	s.m.ForEach(fn)
+}
+
+// SetLimit sets a custom limit for key.
+func (l *Limiter) SetLimit(key string, limit Limit) {
+	l.customLimits.Set(key, limit)
+}
+
+// DeleteLimit removes the custom limit for key so that it falls back to the
+// default limit.
+func (l *Limiter) DeleteLimit(key string) {
+	l.customLimits.Delete(key)
+}
+
+// SetLimits sets a custom limit for every key in limits. With replace, custom
+// limits for keys missing from limits are removed so that limits becomes the
+// full set; otherwise other custom limits are left as they are. Concurrent
+// calls to AllowN may observe the limits while they are being updated.
+func (l *Limiter) SetLimits(limits map[string]Limit, replace bool) {
+	if replace {
+		var stale []string
+		l.customLimits.Range(func(key string, _ Limit) bool {
+			if _, ok := limits[key]; !ok {
+				stale = append(stale, key)
+			}
+			return true
+		})
+		for _, key := range stale {
+			l.customLimits.Delete(key)
+		}
+	}
+	for key, limit := range limits {
+		l.customLimits.Set(key, limit)
+	}
+}
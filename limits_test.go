@@ -0,0 +1,74 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCustomLimitsNil(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)), WithCustomLimits(nil))
+	if policies := l.Policies(); len(policies) != 1 {
+		t.Fatalf("got %d policies, want only the default", len(policies))
+	}
+	l.SetLimit("k", PerMinute(2))
+	res, err := l.Allow(context.Background(), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Limit != PerMinute(2) {
+		t.Fatalf("got limit %v, want the custom limit", res.Limit)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	l.SetLimit("kept", PerMinute(1))
+	l.SetLimit("changed", PerMinute(1))
+	l.SetLimits(map[string]Limit{"changed": PerMinute(2), "added": PerMinute(3)}, false)
+	for key, want := range map[string]Limit{"kept": PerMinute(1), "changed": PerMinute(2), "added": PerMinute(3)} {
+		if got := l.limitFor(key); got != want {
+			t.Errorf("after merging, %q has limit %v, want %v", key, got, want)
+		}
+	}
+
+	l.SetLimits(map[string]Limit{"added": PerMinute(4)}, true)
+	for key, want := range map[string]Limit{"kept": PerMinute(5), "changed": PerMinute(5), "added": PerMinute(4)} {
+		if got := l.limitFor(key); got != want {
+			t.Errorf("after replacing, %q has limit %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestExtraTTL(t *testing.T) {
+	for _, algo := range []Algorithm{AlgoGCRA, AlgoFixedWindow} {
+		l, mr := newTestLimiter(t, WithAlgorithm(algo), WithRateLimit(PerMinute(1)))
+		now := time.Now()
+		mr.SetTime(now)
+		kept := PerMinute(1)
+		kept.ExtraTTL = time.Hour
+		l.SetLimit("kept", kept)
+		ctx := context.Background()
+		for _, key := range []string{"kept", "default"} {
+			if _, err := l.Allow(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ttl := mr.TTL(l.redisKey("kept")); ttl <= time.Hour || ttl > time.Hour+time.Minute {
+			t.Fatalf("algorithm %d: got TTL %v, want the window plus ExtraTTL", algo, ttl)
+		}
+
+		mr.SetTime(now.Add(2 * time.Minute))
+		mr.FastForward(2 * time.Minute)
+		if mr.Exists(l.redisKey("default")) {
+			t.Fatalf("algorithm %d: default key outlived its window", algo)
+		}
+		if !mr.Exists(l.redisKey("kept")) {
+			t.Fatalf("algorithm %d: key with ExtraTTL expired with its window", algo)
+		}
+		res, err := l.Allow(ctx, "kept")
+		if err != nil || res.Allowed != 1 {
+			t.Fatalf("algorithm %d: got %+v, %v, want the key refilled after its window", algo, res, err)
+		}
+	}
+}
@@ -1,20 +1,20 @@
 package rate_limiter
 
-import (
-	"github.com/redis/rueidis"
-)
-
 // Copyright (c) 2017 Pavel Pravosud
 // https://github.com/rwz/redis-gcra/blob/master/vendor/perform_gcra_ratelimit.lua
-var allowN = rueidis.NewLuaScript(`
+var allowN = newScript(gcraState + `
 -- this script has side-effects, so it requires replicate commands mode
 redis.replicate_commands()
 local rate_limit_key = KEYS[1]
 local burst = ARGV[1]
 local rate = ARGV[2]
-local period = ARGV[3]
+local period_ns = tonumber(ARGV[3])
 local cost = tonumber(ARGV[4])
-local emission_interval = period / rate
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local lazy_ttl = tonumber(ARGV[6] or "0") / 1000
+-- the period is passed in nanoseconds so that short periods keep their
+-- precision; the emission interval is converted back to seconds
+local emission_interval = period_ns / rate / 1000000000
 local increment = emission_interval * cost
 local burst_offset = emission_interval * burst
 -- redis returns time as an array containing two integers: seconds of the epoch
@@ -27,13 +27,10 @@ local burst_offset = emission_interval * burst
 local jan_1_2017 = 1483228800
 local now = redis.call("TIME")
 now = (now[1] - jan_1_2017) + (now[2] / 1000000)
-local tat = redis.call("GET", rate_limit_key)
-if not tat then
-  tat = now
-else
-  tat = tonumber(tat)
-end
-tat = math.max(tat, now)
+local tat, ramp = get_tat(rate_limit_key, now, burst_offset)
+local first_seen = tat and 0 or 1
+tat = math.max(tat or now, now)
+local previous = (now - (tat - burst_offset)) / emission_interval
 local new_tat = tat + increment
 local allow_at = new_tat - burst_offset
 local diff = now - allow_at
@@ -42,29 +39,108 @@ if remaining < 0 then
   local reset_after = tat - now
   local retry_after = diff * -1
   return {
-    0, -- allowed
-    0, -- remaining
-    tostring(retry_after),
-    tostring(reset_after),
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", previous,
+    "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+    "first_seen", first_seen,
   }
 end
 local reset_after = new_tat - now
 if reset_after > 0 then
-  redis.call("SET", rate_limit_key, new_tat, "EX", math.ceil(reset_after))
+  local value, ramp_ttl = tat_value(new_tat, ramp, now)
+  local ttl = math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin)
+  if lazy_ttl == 0 then
+    redis.call("SET", rate_limit_key, value, "EX", ttl)
+  elseif redis.call("TTL", rate_limit_key) >= ttl then
+    -- the current expiry still outlives the new tat
+    redis.call("SET", rate_limit_key, value, "KEEPTTL")
+  else
+    redis.call("SET", rate_limit_key, value, "EX", ttl + math.ceil(lazy_ttl))
+  end
 end
 local retry_after = -1
-return {cost, remaining, tostring(retry_after), tostring(reset_after)}
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
 `)
 
-var allowAtMost = rueidis.NewLuaScript(`
+var allowIfRemaining = newScript(gcraState + `
 -- this script has side-effects, so it requires replicate commands mode
 redis.replicate_commands()
 local rate_limit_key = KEYS[1]
 local burst = ARGV[1]
 local rate = ARGV[2]
-local period = ARGV[3]
+local period_ns = tonumber(ARGV[3])
 local cost = tonumber(ARGV[4])
-local emission_interval = period / rate
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local min_remaining = tonumber(ARGV[6])
+local emission_interval = period_ns / rate / 1000000000
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat, ramp = get_tat(rate_limit_key, now, burst_offset)
+local first_seen = tat and 0 or 1
+tat = math.max(tat or now, now)
+local previous = (now - (tat - burst_offset)) / emission_interval
+local new_tat = tat + increment
+local allow_at = new_tat - burst_offset
+local diff = now - allow_at
+local remaining = diff / emission_interval
+-- only consume if at least min_remaining events are left afterwards
+if remaining < min_remaining then
+  local reset_after = tat - now
+  local retry_after = min_remaining * emission_interval - diff
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", math.max(previous, 0),
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", previous,
+    "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+    "first_seen", first_seen,
+  }
+end
+local reset_after = new_tat - now
+if reset_after > 0 then
+  local value, ramp_ttl = tat_value(new_tat, ramp, now)
+  redis.call("SET", rate_limit_key, value, "EX", math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin))
+end
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
+`)
+
+var allowAtMost = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local emission_interval = period_ns / rate / 1000000000
 local burst_offset = emission_interval * burst
 -- redis returns time as an array containing two integers: seconds of the epoch
 -- time (10 digits) and microseconds (6 digits). for convenience we need to
@@ -76,23 +152,25 @@ local burst_offset = emission_interval * burst
 local jan_1_2017 = 1483228800
 local now = redis.call("TIME")
 now = (now[1] - jan_1_2017) + (now[2] / 1000000)
-local tat = redis.call("GET", rate_limit_key)
-if not tat then
-  tat = now
-else
-  tat = tonumber(tat)
-end
-tat = math.max(tat, now)
+local tat, ramp = get_tat(rate_limit_key, now, burst_offset)
+local first_seen = tat and 0 or 1
+tat = math.max(tat or now, now)
 local diff = now - (tat - burst_offset)
 local remaining = diff / emission_interval
-if remaining < 1 then
+local previous = remaining
+-- a cost of 0 only inspects the key, which is denied while it is in debt
+if remaining < 1 and (cost > 0 or remaining < 0) then
   local reset_after = tat - now
   local retry_after = emission_interval - diff
   return {
-    0, -- allowed
-    0, -- remaining
-    tostring(retry_after),
-    tostring(reset_after),
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", previous,
+    "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+    "first_seen", first_seen,
   }
 end
 if remaining < cost then
@@ -104,13 +182,886 @@ end
 local increment = emission_interval * cost
 local new_tat = tat + increment
 local reset_after = new_tat - now
+if reset_after > 0 and cost > 0 then
+  local value, ramp_ttl = tat_value(new_tat, ramp, now)
+  redis.call("SET", rate_limit_key, value, "EX", math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin))
+end
+return {
+  "status", 0, -- ok
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
+`)
+
+var peek = newScript(gcraState + `
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local emission_interval = period_ns / rate / 1000000000
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat = get_tat(rate_limit_key, now, burst_offset)
+tat = math.max(tat or now, now)
+local diff = now - (tat - burst_offset)
+local remaining = diff / emission_interval
+local reset_after = tat - now
+local retry_after = -1
+if remaining < 1 then
+  retry_after = emission_interval - diff
+end
+return {
+  "status", 0, -- ok
+  "allowed", 0,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", remaining,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+}
+`)
+
+var refillBurst = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local ttl = redis.call("PTTL", rate_limit_key)
+if ttl == -2 then
+  return 0
+end
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+if ttl > 0 then
+  redis.call("SET", rate_limit_key, now, "PX", ttl)
+else
+  redis.call("SET", rate_limit_key, now)
+end
+return 1
+`)
+
+var resetIfIdle = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local idle_ms = tonumber(ARGV[1])
+local idle = redis.call("OBJECT", "IDLETIME", rate_limit_key)
+if not idle or idle * 1000 < idle_ms then
+  return 0
+end
+redis.call("DEL", rate_limit_key)
+return 1
+`)
+
+var bumpGeneration = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local generation_key = KEYS[1]
+local ttl_ms = tonumber(ARGV[1])
+-- generations are the server time of the reset in microseconds, so that they
+-- keep increasing after an expired generation key starts over
+local now = redis.call("TIME")
+local generation = now[1] * 1000000 + now[2]
+local previous = tonumber(redis.call("GET", generation_key))
+if previous and generation <= previous then
+  generation = previous + 1
+end
+redis.call("SET", generation_key, generation, "PX", ttl_ms)
+return generation
+`)
+
+// gcraState holds the helpers the GCRA scripts use to read and write the
+// theoretical arrival time of a key. A key being ramped by RampReset stores
+// the start and duration of the ramp after its tat, as "tat:start:over".
+const gcraState = `local function parse_tat(value)
+  if not value then
+    return nil
+  end
+  local tat, start, over = string.match(value, "^([^:]*):([^:]*):([^:]*)$")
+  if not tat then
+    return tonumber(value)
+  end
+  return tonumber(tat), {tonumber(start), tonumber(over)}
+end
+-- get_tat returns the tat of key, or nil, and the ramp it is under. until
+-- the ramp ends the tat is held back so that the remaining events are at
+-- most burst * elapsed / over.
+local function get_tat(key, now, burst_offset)
+  local tat, ramp = parse_tat(redis.call("GET", key))
+  if not ramp or now >= ramp[1] + ramp[2] then
+    return tat
+  end
+  return math.max(tat, now + burst_offset * (1 - (now - ramp[1]) / ramp[2])), ramp
+end
+-- tat_value returns the value storing tat and ramp, and how long the key
+-- must live for the ramp to end.
+local function tat_value(tat, ramp, now)
+  if not ramp then
+    return tat, 0
+  end
+  return string.format("%.17g:%.17g:%.17g", tat, ramp[1], ramp[2]), ramp[1] + ramp[2] - now
+end
+`
+
+var rampReset = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local over = tonumber(ARGV[6]) / 1000000000
+local emission_interval = period_ns / rate / 1000000000
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat = get_tat(rate_limit_key, now, burst_offset)
+if not tat or tat <= now then
+  return 0
+end
+if over <= 0 or burst <= 0 then
+  redis.call("DEL", rate_limit_key)
+  return 1
+end
+-- the ramp starts as far back as the events left make it, and any debt is
+-- forgiven, so that the full burst is back after at most over
+local remaining = math.max((now - (tat - burst_offset)) / emission_interval, 0)
+local start = now - over * remaining / burst
+if tat - now <= start + over - now then
+  -- the key refills sooner on its own
+  return 0
+end
+local value, ramp_ttl = tat_value(now, {start, over}, now)
+redis.call("SET", rate_limit_key, value, "EX", math.ceil(ramp_ttl + ttl_margin))
+return 1
+`)
+
+var transferQuota = newScript(gcraState + `
+local from_key = KEYS[1]
+local to_key = KEYS[2]
+local from_tat = redis.call("GET", from_key)
+if not from_tat then
+  return 0
+end
+local to_tat = redis.call("GET", to_key)
+if not to_tat or parse_tat(from_tat) > parse_tat(to_tat) then
+  local ttl = redis.call("PTTL", from_key)
+  if ttl > 0 then
+    redis.call("SET", to_key, from_tat, "PX", ttl)
+  else
+    redis.call("SET", to_key, from_tat)
+  end
+end
+redis.call("DEL", from_key)
+return 1
+`)
+
+var sharedAllowN = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local usage_key = KEYS[2]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local sub_key = ARGV[6]
+local emission_interval = period_ns / rate / 1000000000
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat = redis.call("GET", rate_limit_key)
+local first_seen = tat and 0 or 1
+if not tat then
+  tat = now
+else
+  tat = tonumber(tat)
+end
+tat = math.max(tat, now)
+local previous = (now - (tat - burst_offset)) / emission_interval
+local new_tat = tat + increment
+local allow_at = new_tat - burst_offset
+local diff = now - allow_at
+local remaining = diff / emission_interval
+if remaining < 0 then
+  local reset_after = tat - now
+  local retry_after = diff * -1
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", previous,
+    "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+    "first_seen", first_seen,
+  }
+end
+local reset_after = new_tat - now
+if reset_after > 0 then
+  local ttl = math.ceil(reset_after + ttl_margin)
+  redis.call("SET", rate_limit_key, new_tat, "EX", ttl)
+  redis.call("HINCRBY", usage_key, sub_key, cost)
+  redis.call("EXPIRE", usage_key, ttl)
+end
+local retry_after = -1
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
+`)
+
+var charge = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local emission_interval = period_ns / rate / 1000000000
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat, ramp = get_tat(rate_limit_key, now, burst_offset)
+local first_seen = tat and 0 or 1
+tat = math.max(tat or now, now)
+local previous = (now - (tat - burst_offset)) / emission_interval
+local new_tat = tat + increment
+local diff = now - (new_tat - burst_offset)
+local remaining = diff / emission_interval
+local reset_after = new_tat - now
 if reset_after > 0 then
-  redis.call("SET", rate_limit_key, new_tat, "EX", math.ceil(reset_after))
+  local value, ramp_ttl = tat_value(new_tat, ramp, now)
+  redis.call("SET", rate_limit_key, value, "EX", math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin))
+end
+local retry_after = -1
+if remaining < 1 then
+  retry_after = emission_interval - diff
+end
+-- a negative remaining is the debt, rounded up to whole events
+if remaining < 0 then
+  remaining = -math.ceil(-remaining)
+end
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
+`)
+
+var scheduleAt = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local reserve = ARGV[6] == "1"
+local emission_interval = period_ns / rate / 1000000000
+local burst_offset = emission_interval * burst
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat, ramp = get_tat(rate_limit_key, now, burst_offset)
+local first_seen = tat and 0 or 1
+tat = math.max(tat or now, now)
+local previous = (now - (tat - burst_offset)) / emission_interval
+local new_tat = tat + emission_interval * cost
+-- the events are permitted once the key has refilled enough for them
+local retry_after = new_tat - burst_offset - now
+if retry_after <= 0 then
+  retry_after = -1
+end
+if not reserve then
+  return {
+    "status", 0,
+    "allowed", 0,
+    "remaining", math.max(previous, 0),
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(tat - now),
+    "previous", math.max(previous, 0),
+    "window_start", tostring(jan_1_2017 + tat - period_ns / 1000000000),
+  }
+end
+local reset_after = new_tat - now
+local value, ramp_ttl = tat_value(new_tat, ramp, now)
+redis.call("SET", rate_limit_key, value, "EX", math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin))
+local remaining = (now - (new_tat - burst_offset)) / emission_interval
+if remaining < 0 then
+  remaining = -math.ceil(-remaining)
+end
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now + reset_after - period_ns / 1000000000),
+  "first_seen", first_seen,
+}
+`)
+
+var refund = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = ARGV[1]
+local rate = ARGV[2]
+local period_ns = tonumber(ARGV[3])
+local tokens = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local emission_interval = period_ns / rate / 1000000000
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local tat, ramp = get_tat(rate_limit_key, now, emission_interval * burst)
+if not tat then
+  return 0
+end
+local new_tat = math.max(tat - emission_interval * tokens, now)
+if new_tat <= now and not ramp then
+  redis.call("DEL", rate_limit_key)
+else
+  local value, ramp_ttl = tat_value(new_tat, ramp, now)
+  redis.call("SET", rate_limit_key, value, "EX", math.ceil(math.max(new_tat - now, ramp_ttl) + ttl_margin))
+end
+return 1
+`)
+
+var fixedWindowAllowN = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period_ms = math.ceil(tonumber(ARGV[3]) / 1000000)
+local cost = tonumber(ARGV[4])
+local ttl_margin_ms = tonumber(ARGV[5])
+local aligned = ARGV[6] == "1"
+local now = redis.call("TIME")
+now = now[1] + (now[2] / 1000000)
+local count = tonumber(redis.call("GET", rate_limit_key) or "0")
+local pttl = redis.call("PTTL", rate_limit_key)
+local first_seen = pttl == -2 and 1 or 0
+-- the key expires ttl_margin_ms after the end of its window
+local ttl = pttl - ttl_margin_ms
+if pttl < 0 or ttl <= 0 then
+  count = 0
+  ttl = period_ms
+end
+if aligned then
+  -- windows start at multiples of the period since the unix epoch. a key
+  -- whose expiry doesn't match the current window is left over from
+  -- another window
+  local window_ttl = period_ms - math.floor(now * 1000) % period_ms
+  if math.abs(ttl - window_ttl) > math.min(period_ms / 2, 1000) then
+    count = 0
+  end
+  ttl = window_ttl
+end
+if count + cost > limit then
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(ttl / 1000),
+    "reset_after", tostring(ttl / 1000),
+    "previous", math.max(limit - count, 0),
+    "window_start", tostring(now - (period_ms - ttl) / 1000),
+    "first_seen", first_seen,
+  }
+end
+local new_count = count + cost
+redis.call("SET", rate_limit_key, new_count, "PX", math.max(ttl, 1) + ttl_margin_ms)
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", limit - new_count,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(ttl / 1000),
+  "previous", math.max(limit - count, 0),
+  "window_start", tostring(now - (period_ms - ttl) / 1000),
+  "first_seen", first_seen,
+}
+`)
+
+var fixedWindowPeek = newScript(`
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period_ms = math.ceil(tonumber(ARGV[3]) / 1000000)
+local ttl_margin_ms = tonumber(ARGV[5])
+local aligned = ARGV[6] == "1"
+local now = redis.call("TIME")
+now = now[1] + (now[2] / 1000000)
+local count = tonumber(redis.call("GET", rate_limit_key) or "0")
+local pttl = redis.call("PTTL", rate_limit_key)
+local ttl = pttl - ttl_margin_ms
+if pttl < 0 or ttl <= 0 then
+  count = 0
+  ttl = period_ms
+end
+if aligned then
+  local window_ttl = period_ms - math.floor(now * 1000) % period_ms
+  if math.abs(ttl - window_ttl) > math.min(period_ms / 2, 1000) then
+    count = 0
+  end
+  ttl = window_ttl
+end
+local retry_after = -1
+if count >= limit then
+  retry_after = ttl / 1000
+end
+local remaining = math.max(limit - count, 0)
+return {
+  "status", 0,
+  "allowed", 0,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(ttl / 1000),
+  "previous", remaining,
+  "window_start", tostring(now - (period_ms - ttl) / 1000),
+}
+`)
+
+var fixedWindowRefund = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local tokens = tonumber(ARGV[4])
+local count = redis.call("GET", rate_limit_key)
+local ttl = redis.call("PTTL", rate_limit_key)
+if not count or ttl < 0 then
+  return 0
+end
+-- the key keeps its expiry, which the end of the current window is read from
+redis.call("SET", rate_limit_key, math.max(tonumber(count) - tokens, 0), "PX", ttl)
+return 1
+`)
+
+var slidingLogAllowN = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period = tonumber(ARGV[3]) / 1000000000
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local first_seen = redis.call("EXISTS", rate_limit_key) == 0 and 1 or 0
+redis.call("ZREMRANGEBYSCORE", rate_limit_key, "-inf", now - period)
+local count = redis.call("ZCARD", rate_limit_key)
+if count + cost > limit then
+  local retry_after = period
+  local reset_after = 0
+  if count > 0 then
+    local newest = redis.call("ZRANGE", rate_limit_key, -1, -1, "WITHSCORES")
+    reset_after = tonumber(newest[2]) + period - now
+  end
+  if cost <= limit and count > 0 then
+    -- wait for enough of the oldest events to leave the window
+    local oldest = redis.call("ZRANGE", rate_limit_key, count + cost - limit - 1, count + cost - limit - 1, "WITHSCORES")
+    retry_after = tonumber(oldest[2]) + period - now
+  end
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", math.max(limit - count, 0),
+    "window_start", tostring(jan_1_2017 + now - period),
+    "first_seen", first_seen,
+  }
+end
+for i = 1, cost do
+  redis.call("ZADD", rate_limit_key, now, tostring(now) .. ":" .. (count + i))
+end
+redis.call("EXPIRE", rate_limit_key, math.ceil(period + ttl_margin))
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", limit - count - cost,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(period),
+  "previous", limit - count,
+  "window_start", tostring(jan_1_2017 + now - period),
+  "first_seen", first_seen,
+}
+`)
+
+var slidingLogPeek = newScript(`
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period = tonumber(ARGV[3]) / 1000000000
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local count = redis.call("ZCOUNT", rate_limit_key, "(" .. (now - period), "+inf")
+local retry_after = -1
+local reset_after = 0
+if count > 0 then
+  local newest = redis.call("ZRANGE", rate_limit_key, -1, -1, "WITHSCORES")
+  reset_after = tonumber(newest[2]) + period - now
+end
+if count >= limit then
+  local oldest = redis.call("ZRANGEBYSCORE", rate_limit_key, "(" .. (now - period), "+inf", "WITHSCORES", "LIMIT", count - limit, 1)
+  retry_after = tonumber(oldest[2]) + period - now
+end
+local remaining = math.max(limit - count, 0)
+return {
+  "status", 0,
+  "allowed", 0,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", remaining,
+  "window_start", tostring(jan_1_2017 + now - period),
+}
+`)
+
+var slidingLogRefund = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local tokens = tonumber(ARGV[4])
+if tokens < 1 or redis.call("EXISTS", rate_limit_key) == 0 then
+  return 0
+end
+-- the newest events are the ones being given back
+redis.call("ZREMRANGEBYRANK", rate_limit_key, -tokens, -1)
+return 1
+`)
+
+var slidingWindowAllowN = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period = tonumber(ARGV[3]) / 1000000000
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local buckets = tonumber(ARGV[6])
+local bucket_size = period / buckets
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local current = math.floor(now / bucket_size)
+local elapsed = now / bucket_size - current
+-- the oldest bucket only partly overlaps the window, so its count is
+-- weighted by the overlap
+local count = 0
+local counts = redis.call("HGETALL", rate_limit_key)
+local first_seen = #counts == 0 and 1 or 0
+for i = 1, #counts, 2 do
+  local bucket = tonumber(counts[i])
+  local n = tonumber(counts[i + 1])
+  if bucket < current - buckets then
+    redis.call("HDEL", rate_limit_key, counts[i])
+  elseif bucket == current - buckets then
+    count = count + n * (1 - elapsed)
+  else
+    count = count + n
+  end
+end
+local previous = math.max(limit - count, 0)
+local reset_after = (current + 1) * bucket_size + period - now
+if count + cost > limit then
+  local retry_after = (current + 1) * bucket_size - now
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(retry_after),
+    "reset_after", tostring(reset_after),
+    "previous", previous,
+    "window_start", tostring(jan_1_2017 + now - period),
+    "first_seen", first_seen,
+  }
+end
+redis.call("HINCRBY", rate_limit_key, current, cost)
+redis.call("EXPIRE", rate_limit_key, math.ceil(reset_after + ttl_margin))
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", limit - count - cost,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(reset_after),
+  "previous", previous,
+  "window_start", tostring(jan_1_2017 + now - period),
+  "first_seen", first_seen,
+}
+`)
+
+var slidingWindowPeek = newScript(`
+local rate_limit_key = KEYS[1]
+local limit = tonumber(ARGV[2])
+local period = tonumber(ARGV[3]) / 1000000000
+local buckets = tonumber(ARGV[6])
+local bucket_size = period / buckets
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+local current = math.floor(now / bucket_size)
+local elapsed = now / bucket_size - current
+local count = 0
+local counts = redis.call("HGETALL", rate_limit_key)
+for i = 1, #counts, 2 do
+  local bucket = tonumber(counts[i])
+  local n = tonumber(counts[i + 1])
+  if bucket == current - buckets then
+    count = count + n * (1 - elapsed)
+  elseif bucket > current - buckets then
+    count = count + n
+  end
+end
+local remaining = math.max(limit - count, 0)
+local retry_after = -1
+if remaining < 1 then
+  retry_after = (current + 1) * bucket_size - now
+end
+local reset_after = 0
+if #counts > 0 then
+  reset_after = (current + 1) * bucket_size + period - now
+end
+return {
+  "status", 0,
+  "allowed", 0,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", remaining,
+  "window_start", tostring(jan_1_2017 + now - period),
+}
+`)
+
+var slidingWindowRefund = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local tokens = tonumber(ARGV[4])
+local counts = redis.call("HGETALL", rate_limit_key)
+if #counts == 0 then
+  return 0
+end
+-- the events are taken back from the newest buckets first
+local buckets = {}
+for i = 1, #counts, 2 do
+  table.insert(buckets, {field = counts[i], n = tonumber(counts[i + 1])})
+end
+table.sort(buckets, function(a, b) return tonumber(a.field) > tonumber(b.field) end)
+for _, bucket in ipairs(buckets) do
+  if tokens <= 0 then
+    break
+  end
+  if bucket.n <= tokens then
+    redis.call("HDEL", rate_limit_key, bucket.field)
+  else
+    redis.call("HINCRBY", rate_limit_key, bucket.field, -tokens)
+  end
+  tokens = tokens - bucket.n
+end
+return 1
+`)
+
+var steppedRefillAllowN = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local period_ms = math.ceil(tonumber(ARGV[3]) / 1000000)
+local cost = tonumber(ARGV[4])
+local ttl_margin_ms = tonumber(ARGV[5])
+local now = redis.call("TIME")
+local now_ms = now[1] * 1000 + math.floor(now[2] / 1000)
+-- the burst is restored at every multiple of the period since the unix epoch
+local window_start_ms = now_ms - now_ms % period_ms
+local reset_after = (window_start_ms + period_ms - now_ms) / 1000
+local state = redis.call("HMGET", rate_limit_key, "window", "used")
+local first_seen = state[1] and 0 or 1
+local used = 0
+if tonumber(state[1]) == window_start_ms then
+  used = tonumber(state[2])
+end
+if used + cost > burst then
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(reset_after),
+    "reset_after", tostring(reset_after),
+    "previous", math.max(burst - used, 0),
+    "window_start", tostring(window_start_ms / 1000),
+    "first_seen", first_seen,
+  }
+end
+redis.call("HSET", rate_limit_key, "window", window_start_ms, "used", used + cost)
+redis.call("PEXPIREAT", rate_limit_key, window_start_ms + period_ms + ttl_margin_ms)
+return {
+  "status", 0,
+  "allowed", cost,
+  "remaining", burst - used - cost,
+  "retry_after", tostring(-1),
+  "reset_after", tostring(reset_after),
+  "previous", burst - used,
+  "window_start", tostring(window_start_ms / 1000),
+  "first_seen", first_seen,
+}
+`)
+
+var steppedRefillPeek = newScript(`
+local rate_limit_key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local period_ms = math.ceil(tonumber(ARGV[3]) / 1000000)
+local now = redis.call("TIME")
+local now_ms = now[1] * 1000 + math.floor(now[2] / 1000)
+local window_start_ms = now_ms - now_ms % period_ms
+local reset_after = (window_start_ms + period_ms - now_ms) / 1000
+local state = redis.call("HMGET", rate_limit_key, "window", "used")
+local used = 0
+if tonumber(state[1]) == window_start_ms then
+  used = tonumber(state[2])
+end
+local retry_after = -1
+if used >= burst then
+  retry_after = reset_after
+end
+local remaining = math.max(burst - used, 0)
+return {
+  "status", 0,
+  "allowed", 0,
+  "remaining", remaining,
+  "retry_after", tostring(retry_after),
+  "reset_after", tostring(reset_after),
+  "previous", remaining,
+  "window_start", tostring(window_start_ms / 1000),
+}
+`)
+
+var steppedRefillRefund = newScript(`
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local rate_limit_key = KEYS[1]
+local period_ms = math.ceil(tonumber(ARGV[3]) / 1000000)
+local tokens = tonumber(ARGV[4])
+local now = redis.call("TIME")
+local now_ms = now[1] * 1000 + math.floor(now[2] / 1000)
+local window_start_ms = now_ms - now_ms % period_ms
+local state = redis.call("HMGET", rate_limit_key, "window", "used")
+-- events used in an earlier window have already been restored
+if tonumber(state[1]) ~= window_start_ms then
+  return 0
+end
+redis.call("HSET", rate_limit_key, "used", math.max(tonumber(state[2]) - tokens, 0))
+return 1
+`)
+
+var hierarchyAllowN = newScript(gcraState + `
+-- this script has side-effects, so it requires replicate commands mode
+redis.replicate_commands()
+local cost = tonumber(ARGV[4])
+local ttl_margin = tonumber(ARGV[5]) / 1000
+local jan_1_2017 = 1483228800
+local now = redis.call("TIME")
+now = (now[1] - jan_1_2017) + (now[2] / 1000000)
+-- every level is checked before any is written so that a denial at one
+-- level consumes nothing at the others
+local tats, new_tats, remainings, previouses, periods, ramps = {}, {}, {}, {}, {}, {}
+local level, denied, denied_diff
+for i = 1, #KEYS do
+  local burst = tonumber(ARGV[3 + 3 * i])
+  local rate = tonumber(ARGV[4 + 3 * i])
+  local period = tonumber(ARGV[5 + 3 * i]) / 1000000000
+  local emission_interval = period / rate
+  local burst_offset = emission_interval * burst
+  local tat, ramp = get_tat(KEYS[i], now, burst_offset)
+  tat = math.max(tat or now, now)
+  local new_tat = tat + emission_interval * cost
+  local diff = now - (new_tat - burst_offset)
+  tats[i] = tat
+  new_tats[i] = new_tat
+  remainings[i] = diff / emission_interval
+  previouses[i] = (now - (tat - burst_offset)) / emission_interval
+  periods[i] = period
+  ramps[i] = ramp
+  if not denied and remainings[i] < 0 then
+    denied = true
+    denied_diff = diff
+    level = i
+  elseif not denied and (not level or remainings[i] < remainings[level]) then
+    level = i
+  end
+end
+-- the state of every level is returned as tiers, in {remaining,
+-- reset_after} pairs
+local tiers = {}
+if denied then
+  for i = 1, #KEYS do
+    table.insert(tiers, math.max(previouses[i], 0))
+    table.insert(tiers, tostring(tats[i] - now))
+  end
+  local reset_after = tats[level] - now
+  return {
+    "status", 1, -- denied
+    "allowed", 0,
+    "remaining", 0,
+    "retry_after", tostring(denied_diff * -1),
+    "reset_after", tostring(reset_after),
+    "previous", math.max(previouses[level], 0),
+    "window_start", tostring(jan_1_2017 + now + reset_after - periods[level]),
+    "level", level,
+    "tiers", tiers,
+  }
+end
+for i = 1, #KEYS do
+  local reset_after = new_tats[i] - now
+  if reset_after > 0 then
+    local value, ramp_ttl = tat_value(new_tats[i], ramps[i], now)
+    redis.call("SET", KEYS[i], value, "EX", math.ceil(math.max(reset_after, ramp_ttl) + ttl_margin))
+  end
+  table.insert(tiers, remainings[i])
+  table.insert(tiers, tostring(reset_after))
 end
+local level_reset_after = new_tats[level] - now
 return {
-  cost,
-  remaining,
-  tostring(-1),
-  tostring(reset_after),
+  "status", 0, -- ok
+  "allowed", cost,
+  "remaining", remainings[level],
+  "retry_after", tostring(-1),
+  "reset_after", tostring(level_reset_after),
+  "previous", previouses[level],
+  "window_start", tostring(jan_1_2017 + now + level_reset_after - periods[level]),
+  "level", level,
+  "tiers", tiers,
 }
 `)
@@ -0,0 +1,140 @@
+package rate_limiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/rueidis"
+)
+
+// KeyErrors holds the errors of a call covering several keys, in the same
+// order as the keys. Keys that succeeded have a nil error.
+type KeyErrors []error
+
+func (e KeyErrors) Error() string {
+	failed := e.Unwrap()
+	switch len(failed) {
+	case 0:
+		return "rate_limiter: no errors"
+	case 1:
+		return failed[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", failed[0], len(failed)-1)
+}
+
+// Unwrap returns the non-nil errors so that errors.Is and errors.As match any
+// of them.
+func (e KeyErrors) Unwrap() []error {
+	var failed []error
+	for _, err := range e {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// err returns e, or nil if no key failed.
+func (e KeyErrors) err() error {
+	for _, err := range e {
+		if err != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// AllowMany is like AllowN for several keys at once, each consuming n events
+// independently. The scripts are pipelined and the results are returned in
+// the same order as keys. Every key goes through the same steps as with
+// AllowN, such as penalties, denial counts, sequences and generations, which
+// may take round trips of their own. If some keys fail, the error is a
+// KeyErrors and the results of the other keys are still returned.
+func (l Limiter) AllowMany(ctx context.Context, keys []string, n int) ([]*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if l.maxN > 0 && n > l.maxN {
+		return nil, ErrNExceedsMax
+	}
+	results := make([]*Result, len(keys))
+	errs := make(KeyErrors, len(keys))
+	calls := make([]call, len(keys))
+	// keys are grouped by script, one pipeline per algorithm in use
+	groups := make(map[*script][]int)
+	for i, key := range keys {
+		if errs[i] = l.validateKeys(key); errs[i] != nil {
+			continue
+		}
+		c := l.allowCall(key, n)
+		switch {
+		case !l.enabled.Load(), l.bypass != nil && l.bypass(key):
+			results[i] = l.allowedResult(c.limit, n)
+		case c.limit.Burst == 0:
+			results[i] = l.blockedResult(c.limit)
+		case !l.breaker.allow():
+			results[i], errs[i] = l.failResult(c.limit, n, ErrCircuitOpen)
+		default:
+			if c.prepare != nil {
+				c.prepare(ctx, &c)
+			}
+			if c.limit.Burst == 0 {
+				// penalized, see exec
+				l.breaker.record(nil)
+				results[i] = l.blockedResult(c.limit)
+				break
+			}
+			groups[c.script] = append(groups[c.script], i)
+		}
+		calls[i] = c
+	}
+	for s, indexes := range groups {
+		execs := make([]rueidis.LuaExec, len(indexes))
+		for j, i := range indexes {
+			execs[j] = rueidis.LuaExec{
+				Keys: calls[i].keys,
+				Args: append(l.limitArgs(calls[i].limit, n), calls[i].args...),
+			}
+		}
+		for j, resp := range l.evalMulti(ctx, s, execs) {
+			i := indexes[j]
+			result, err := scriptResult(resp)
+			l.breaker.record(err)
+			if err != nil {
+				results[i], errs[i] = l.failResult(calls[i].limit, n, err)
+				continue
+			}
+			results[i], errs[i] = l.newResult(calls[i].limit, n, result)
+		}
+	}
+	for i, res := range results {
+		if res != nil {
+			res.Rejected = max(n-res.Allowed, 0)
+			l.applyAlgorithm(calls[i].algorithm, res)
+			l.checkSoftLimit(calls[i], res)
+			l.checkThreshold(calls[i], res)
+			l.audit(ctx, calls[i], res)
+			results[i], errs[i] = l.afterAllow(ctx, keys[i], res, errs[i])
+		}
+	}
+	return results, errs.err()
+}
+
+// DeniedKeys returns the keys for which n events would currently be denied,
+// in the same order as keys, without consuming any events. It peeks every key
+// like PeekMany, so a key that is allowed now may still be denied by a later
+// AllowN.
+func (l Limiter) DeniedKeys(ctx context.Context, keys []string, n int) ([]string, error) {
+	results, err := l.PeekMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	var denied []string
+	for i, res := range results {
+		if res.Remaining < n || res.Debt > 0 || res.Reason == ReasonBlocked {
+			denied = append(denied, keys[i])
+		}
+		res.Release()
+	}
+	return denied, nil
+}
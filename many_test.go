@@ -0,0 +1,78 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAllowManyPartialFailure(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	mr.HSet(l.redisKey("bad"), "field", "value")
+	results, err := l.AllowMany(context.Background(), []string{"a", "bad", "b"}, 1)
+	var errs KeyErrors
+	if !errors.As(err, &errs) || len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("got %v, want only the second key to fail", err)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i] == nil || results[i].Allowed != 1 || results[i].Remaining != 4 {
+			t.Fatalf("key %d: got %+v, want its events allowed", i, results[i])
+		}
+	}
+}
+
+func TestAllowManySteps(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithPenalty(Limit{}, time.Minute),
+		WithConsecutiveDenials(), WithSequence(), WithCardinalityTracking())
+	ctx := context.Background()
+	keys := []string{"a", "b"}
+	for i, want := range []struct {
+		reason   Reason
+		sequence int64
+		denials  int
+	}{
+		{ReasonNone, 1, 0},
+		{ReasonLimitExceeded, 0, 1},
+		{ReasonBlocked, 0, 0},
+	} {
+		results, err := l.AllowMany(ctx, keys, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, res := range results {
+			if res.Reason != want.reason || res.Sequence != want.sequence || res.ConsecutiveDenials != want.denials {
+				t.Fatalf("call %d, key %q: got %+v, want %+v", i, keys[j], res, want)
+			}
+		}
+	}
+	if n, err := l.DistinctKeys(ctx); err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 distinct keys", n, err)
+	}
+}
+
+func TestDeniedKeys(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(2)))
+	ctx := context.Background()
+	for key, n := range map[string]int{"a": 2, "b": 1, "d": 2} {
+		if _, err := l.AllowN(ctx, key, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{"a", "b", "c", "d"}
+	denied, err := l.DeniedKeys(ctx, keys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(denied, []string{"a", "d"}) {
+		t.Fatalf("got %v, want the exhausted keys", denied)
+	}
+	denied, err = l.DeniedKeys(ctx, keys, 2)
+	if err != nil || !slices.Equal(denied, []string{"a", "b", "d"}) {
+		t.Fatalf("got %v, %v for 2 events, want the keys with fewer remaining", denied, err)
+	}
+	if res, err := l.Peek(ctx, "b"); err != nil || res.Remaining != 1 {
+		t.Fatalf("got %+v, %v, want nothing consumed", res, err)
+	}
+}
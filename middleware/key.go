@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// KeyByHeaders returns a key function joining the values of the given
+// request headers with ":", for example to key by an API key header. Missing
+// headers contribute an empty value.
+func KeyByHeaders(headers ...string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		values := make([]string, len(headers))
+		for i, h := range headers {
+			values[i] = r.Header.Get(h)
+		}
+		return strings.Join(values, ":")
+	}
+}
+
+// KeyByIP returns a key function using the client IP, for services behind
+// trustedProxies reverse proxies. The client IP is taken from the
+// X-Forwarded-For chain, skipping the addresses added by the trusted
+// proxies, and falls back to X-Real-IP. With no trusted proxies the
+// forwarding headers are ignored since any client can set them, and the
+// remote address is used as by default.
+func KeyByIP(trustedProxies int) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if trustedProxies <= 0 {
+			return remoteHost(r)
+		}
+		// the nearest proxy is the remote address, every other trusted
+		// proxy appended the address it received the request from
+		var chain []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(h, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					chain = append(chain, addr)
+				}
+			}
+		}
+		if len(chain) == 0 {
+			if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+				return ip
+			}
+			return remoteHost(r)
+		}
+		i := max(len(chain)-trustedProxies, 0)
+		return stripPort(chain[i])
+	}
+}
+
+// stripPort removes the port from addr if it has one.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
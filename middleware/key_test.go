@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyByHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Api-Key", "secret")
+	if got := KeyByHeaders("X-Forwarded-For", "X-Api-Key")(r); got != "203.0.113.7:secret" {
+		t.Fatalf("got %q, want both headers joined", got)
+	}
+	if got := KeyByHeaders("X-Api-Key", "X-Missing")(r); got != "secret:" {
+		t.Fatalf("got %q, want an empty value for the missing header", got)
+	}
+}
+
+func TestKeyByIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies int
+		headers        map[string]string
+		want           string
+	}{
+		{"no headers", 1, nil, "192.0.2.1"},
+		{"untrusted forwarding", 0, map[string]string{"X-Forwarded-For": "203.0.113.7"}, "192.0.2.1"},
+		{"one proxy", 1, map[string]string{"X-Forwarded-For": "198.51.100.2, 203.0.113.7"}, "203.0.113.7"},
+		{"two proxies", 2, map[string]string{"X-Forwarded-For": "198.51.100.2, 203.0.113.7"}, "198.51.100.2"},
+		{"more proxies than hops", 5, map[string]string{"X-Forwarded-For": "203.0.113.7:4711"}, "203.0.113.7"},
+		{"real IP", 1, map[string]string{"X-Real-IP": "203.0.113.9"}, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := KeyByIP(tt.trustedProxies)(r); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
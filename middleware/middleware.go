@@ -0,0 +1,126 @@
+// Package middleware provides HTTP middleware that rate limits requests with a
+// rate_limiter.Limiter.
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	rl "github.com/jsjain/go-rate-limiter"
+)
+
+// DeniedHandler writes the response for a request that was rate limited.
+type DeniedHandler func(w http.ResponseWriter, r *http.Request, res *rl.Result)
+
+type options struct {
+	keyFunc        func(*http.Request) string
+	deniedHandler  DeniedHandler
+	defaultHeaders bool
+	burstHeader    bool
+}
+
+// Option configures the middleware.
+type Option func(*options)
+
+// WithKeyFunc sets the function deriving the rate limiting key from a
+// request. The default uses the host of the request's remote address.
+func WithKeyFunc(fn func(*http.Request) string) Option {
+	return func(o *options) {
+		o.keyFunc = fn
+	}
+}
+
+// WithDeniedHandler sets the handler writing the response for denied
+// requests. The rate limit headers are set before it runs unless
+// WithoutDefaultHeaders is given. The default writes 429 Too Many Requests.
+func WithDeniedHandler(h DeniedHandler) Option {
+	return func(o *options) {
+		o.deniedHandler = h
+	}
+}
+
+// WithoutDefaultHeaders stops the middleware from setting the X-RateLimit-*
+// and Retry-After headers.
+func WithoutDefaultHeaders() Option {
+	return func(o *options) {
+		o.defaultHeaders = false
+	}
+}
+
+// WithBurstHeader makes the middleware also set X-RateLimit-Burst to the
+// limit's burst when it differs from its rate, so that clients can tell the
+// burst allowance from the sustained rate.
+func WithBurstHeader() Option {
+	return func(o *options) {
+		o.burstHeader = true
+	}
+}
+
+// New returns middleware that calls Allow on limiter for every request and
+// only passes allowed requests on to the next handler.
+func New(limiter *rl.Limiter, opts ...Option) func(http.Handler) http.Handler {
+	o := &options{
+		keyFunc:        remoteHost,
+		deniedHandler:  TooManyRequests,
+		defaultHeaders: true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := limiter.Allow(r.Context(), o.keyFunc(r))
+			if err != nil && !errors.Is(err, rl.ErrRateLimited) {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if o.defaultHeaders {
+				setHeaders(w.Header(), res)
+			}
+			if o.burstHeader && res.Limit.Burst != res.Limit.Rate {
+				w.Header().Set("X-RateLimit-Burst", strconv.Itoa(res.Limit.Burst))
+			}
+			if res.Allowed == 0 {
+				o.deniedHandler(w, r, res)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TooManyRequests is the default DeniedHandler. It responds with 429 Too Many
+// Requests.
+func TooManyRequests(w http.ResponseWriter, r *http.Request, res *rl.Result) {
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+func setHeaders(h http.Header, res *rl.Result) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit.Burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("X-RateLimit-Reset", seconds(res.ResetAfter))
+	if res.Allowed == 0 && res.RetryAfter > 0 {
+		h.Set("Retry-After", seconds(res.RetryAfter))
+	}
+}
+
+// seconds formats d as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
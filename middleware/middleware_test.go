@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rl "github.com/jsjain/go-rate-limiter"
+	"github.com/redis/rueidis"
+)
+
+func newTestLimiter(t *testing.T, opts ...rl.LimiterOption) *rl.Limiter {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:  []string{mr.Addr()},
+		DisableCache: true,
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rdb.Close)
+	return rl.NewLimiter(rdb, opts...)
+}
+
+var noContent = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+// serve sends a request through h and returns the recorded response.
+func serve(h http.Handler) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestDefaultDeniedHandler(t *testing.T) {
+	h := New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(1))))(noContent)
+	if w := serve(h); w.Code != http.StatusNoContent || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("got %d with headers %v, want the request passed on", w.Code, w.Header())
+	}
+	w := serve(h)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("got Retry-After %q, want 60", got)
+	}
+}
+
+func TestCustomDeniedHandler(t *testing.T) {
+	denied := func(w http.ResponseWriter, r *http.Request, res *rl.Result) {
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("headers weren't set before the denied handler ran")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]int{"remaining": res.Remaining})
+	}
+	h := New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(1))), WithDeniedHandler(denied))(noContent)
+	serve(h)
+	w := serve(h)
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "{\"remaining\":0}\n" {
+		t.Fatalf("got %d %q, want the custom response", w.Code, w.Body)
+	}
+
+	h = New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(1))), WithoutDefaultHeaders(),
+		WithDeniedHandler(func(w http.ResponseWriter, r *http.Request, res *rl.Result) {
+			if len(w.Header()) != 0 {
+				t.Errorf("got headers %v, want none", w.Header())
+			}
+			w.WriteHeader(http.StatusTeapot)
+		}))(noContent)
+	serve(h)
+	if w := serve(h); w.Code != http.StatusTeapot {
+		t.Fatalf("got %d, want the custom status", w.Code)
+	}
+}
+
+func TestBurstHeader(t *testing.T) {
+	limit := rl.Limit{Rate: 10, Burst: 20, Period: time.Minute}
+	h := New(newTestLimiter(t, rl.WithRateLimit(limit)), WithBurstHeader())(noContent)
+	if got := serve(h).Header().Get("X-RateLimit-Burst"); got != "20" {
+		t.Fatalf("got X-RateLimit-Burst %q, want 20", got)
+	}
+
+	h = New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(10))), WithBurstHeader())(noContent)
+	if w := serve(h); w.Header().Values("X-RateLimit-Burst") != nil {
+		t.Fatalf("got X-RateLimit-Burst %q for a burst equal to the rate", w.Header().Get("X-RateLimit-Burst"))
+	}
+	h = New(newTestLimiter(t, rl.WithRateLimit(limit)))(noContent)
+	if w := serve(h); w.Header().Values("X-RateLimit-Burst") != nil {
+		t.Fatal("got X-RateLimit-Burst without the option")
+	}
+}
@@ -0,0 +1,92 @@
+package rate_limiter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidLimit is returned by ParseLimit for malformed limits.
+var ErrInvalidLimit = errors.New("rate_limiter: invalid limit")
+
+// ParseLimit parses a limit written as "rate/period" with an optional
+// ",burst", such as "100/m" or "10/30s,20". The period is s, m, h, d or a
+// duration accepted by time.ParseDuration. The burst defaults to the rate. A
+// rate of 0 gives a limit denying every event and can't have a burst.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	spec, burst, hasBurst := strings.Cut(s, ",")
+	rate, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%w %q", ErrInvalidLimit, s)
+	}
+	var limit Limit
+	var err error
+	if limit.Rate, err = strconv.Atoi(strings.TrimSpace(rate)); err != nil || limit.Rate < 0 {
+		return Limit{}, fmt.Errorf("%w %q: bad rate", ErrInvalidLimit, s)
+	}
+	if limit.Period, err = parsePeriod(strings.TrimSpace(period)); err != nil || limit.Period <= 0 {
+		return Limit{}, fmt.Errorf("%w %q: bad period", ErrInvalidLimit, s)
+	}
+	limit.Burst = limit.Rate
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || limit.Burst < 0 {
+			return Limit{}, fmt.Errorf("%w %q: bad burst", ErrInvalidLimit, s)
+		}
+	}
+	// a rate of 0 never refills, so only the limit denying everything has it
+	if limit.Rate == 0 && limit.Burst != 0 {
+		return Limit{}, fmt.Errorf("%w %q: burst without a rate", ErrInvalidLimit, s)
+	}
+	return limit, nil
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	switch s {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	case "d":
+		return 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// LoadLimits returns the limits declared by the rate tags of the fields of
+// cfg, a struct or a pointer to one. Tags use the ParseLimit format, as in
+// `rate:"100/m"`. Limits are keyed by the field's key tag, or its name if it
+// has none.
+func LoadLimits(cfg interface{}) (map[string]Limit, error) {
+	v := reflect.ValueOf(cfg)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rate_limiter: LoadLimits expects a struct, got %T", cfg)
+	}
+	t := v.Type()
+	limits := make(map[string]Limit)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("rate")
+		if !ok {
+			continue
+		}
+		limit, err := ParseLimit(tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		key := field.Name
+		if k, ok := field.Tag.Lookup("key"); ok {
+			key = k
+		}
+		limits[key] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,55 @@
+package rate_limiter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+	}{
+		{"100/m", Limit{Rate: 100, Burst: 100, Period: time.Minute}},
+		{" 10 / 30s , 20 ", Limit{Rate: 10, Burst: 20, Period: 30 * time.Second}},
+		{"0/h", Limit{Period: time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "10", "10/", "-1/s", "10/0s", "10/s,-1", "0/s,5", "x/s"} {
+		if _, err := ParseLimit(in); !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("ParseLimit(%q) = %v, want ErrInvalidLimit", in, err)
+		}
+	}
+}
+
+func FuzzParseLimit(f *testing.F) {
+	for _, s := range []string{"100/m", "10/30s,20", "0/h", "0/s,5", "1/1ns,0", "5/d"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		limit, err := ParseLimit(s)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidLimit) {
+				t.Fatalf("ParseLimit(%q) returned %v, not an ErrInvalidLimit", s, err)
+			}
+			return
+		}
+		if limit.Rate < 0 || limit.Burst < 0 || limit.Period <= 0 {
+			t.Fatalf("ParseLimit(%q) = %v, want positive values", s, limit)
+		}
+		if limit.Rate == 0 && limit.Burst != 0 {
+			t.Fatalf("ParseLimit(%q) = %v, a burst that never refills", s, limit)
+		}
+		again, err := ParseLimit(fmt.Sprintf("%d/%s,%d", limit.Rate, limit.Period, limit.Burst))
+		if err != nil || again != limit {
+			t.Fatalf("ParseLimit(%q) = %v, which parses back as %v, %v", s, limit, again, err)
+		}
+	})
+}
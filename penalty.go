@@ -0,0 +1,44 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+)
+
+type penalty struct {
+	limit    Limit
+	duration time.Duration
+}
+
+// WithPenalty makes AllowN enforce penaltyLimit instead of a key's own limit
+// for duration after the key was denied, for example to slow down clients
+// that keep hitting their limit. Every denial, including those under the
+// penalty, starts a new penalty. The penalty is stored next to the key and
+// checking it costs an extra round trip per call.
+func WithPenalty(penaltyLimit Limit, duration time.Duration) LimiterOption {
+	return func(l *Limiter) {
+		l.penalty = &penalty{limit: penaltyLimit, duration: duration}
+	}
+}
+
+func (l Limiter) penaltyKey(key string) string {
+	return l.auxKey("penalty", key)
+}
+
+// penalized reports whether key is serving a penalty. It only runs for calls
+// that reach Redis. Errors are treated as no penalty, leaving it to the call
+// itself to fail.
+func (l Limiter) penalized(ctx context.Context, key string) bool {
+	n, err := l.rdb.Do(ctx, l.rdb.B().Exists().Key(l.penaltyKey(key)).Build()).AsInt64()
+	return err == nil && n > 0
+}
+
+// withPenalty starts a penalty for key if res denied events.
+func (l Limiter) withPenalty(ctx context.Context, key string, res *Result, err error) (*Result, error) {
+	if l.penalty == nil || err != nil || res == nil || !res.fromRedis || res.Reason != ReasonLimitExceeded {
+		return res, err
+	}
+	cmd := l.rdb.B().Set().Key(l.penaltyKey(key)).Value("1").
+		Px(l.penalty.duration).Build()
+	return res, l.extraErr(l.rdb.Do(ctx, cmd).Error())
+}
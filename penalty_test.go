@@ -0,0 +1,82 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPenalty(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithPenalty(Limit{}, time.Minute))
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Reason != ReasonLimitExceeded {
+		t.Fatalf("got %+v, %v, want a denial", res, err)
+	}
+	res, err = l.Allow(ctx, "k")
+	if err != nil || res.Reason != ReasonBlocked {
+		t.Fatalf("got %+v, %v, want the penalty's blocked limit", res, err)
+	}
+}
+
+func TestPenaltyGates(t *testing.T) {
+	l, mr := newTestLimiter(t, WithPenalty(Limit{}, time.Minute), WithCircuitBreaker(1, time.Minute), WithFailureMode(FailOpen))
+	ctx := context.Background()
+
+	l.SetEnabled(false)
+	before := mr.CommandCount()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("disabled limiter sent %d commands", n)
+	}
+	l.SetEnabled(true)
+
+	mr.SetError("ERR unavailable")
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetError("")
+	before = mr.CommandCount()
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the failure mode's result", res, err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("open breaker let %d commands through", n)
+	}
+}
+
+func TestPenaltyReleasesProbe(t *testing.T) {
+	now := time.Now()
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithPenalty(Limit{}, time.Hour),
+		WithCircuitBreaker(1, time.Minute), WithFailureMode(FailOpen),
+		WithClock(func() time.Time { return now }))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.SetError("ERR unavailable")
+	if _, err := l.Allow(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetError("")
+
+	now = now.Add(time.Minute)
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Reason != ReasonBlocked {
+		t.Fatalf("probe: got %+v, %v, want the penalty's blocked limit", res, err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(ctx, "other")
+		if err != nil || !res.fromRedis {
+			t.Fatalf("call %d after the probe: got %+v, %v, want a result from Redis", i, res, err)
+		}
+	}
+}
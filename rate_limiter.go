@@ -2,20 +2,39 @@ package rate_limiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alphadose/haxmap"
 	"github.com/redis/rueidis"
+	"golang.org/x/time/rate"
 )
 
 const redisPrefix = "rl:"
 
+// Limit describes a rate of Rate events per Period with bursts of up to
+// Burst events. A Limit with a zero Burst denies every event.
 type Limit struct {
 	Rate   int
 	Burst  int
 	Period time.Duration
+	// SoftLimit, if positive, is a number of used events past which
+	// consuming calls report Result.OverSoftLimit. Events are still allowed
+	// until the limit itself is reached.
+	SoftLimit int
+	// ExtraTTL, if positive, replaces the TTL margin set by WithTTLMargin
+	// for keys with this limit, keeping their state in Redis for ExtraTTL
+	// after they have fully refilled, for example to inspect a daily limit
+	// for a week. It extends the expiry computed for a key instead of
+	// replacing it since the algorithms rely on that expiry, for example to
+	// tell where a fixed window ends.
+	ExtraTTL time.Duration
 }
 
 func (l Limit) String() string {
@@ -26,6 +45,23 @@ func (l Limit) IsZero() bool {
 	return l == Limit{}
 }
 
+// PerSecondRate returns the limit's rate normalized to events per second, so
+// that limits with different periods can be compared. It returns 0 for a
+// zero period.
+func (l Limit) PerSecondRate() float64 {
+	if l.Period <= 0 {
+		return 0
+	}
+	return float64(l.Rate) / l.Period.Seconds()
+}
+
+// ToXRate returns the parameters of a golang.org/x/time/rate limiter
+// equivalent to l: its rate in events per second and its burst, as taken by
+// rate.NewLimiter.
+func (l Limit) ToXRate() (rate.Limit, int) {
+	return rate.Limit(l.PerSecondRate()), l.Burst
+}
+
 func fmtDur(d time.Duration) string {
 	switch d {
 	case time.Second:
@@ -74,17 +110,65 @@ func PerDay(rate int) Limit {
 
 // Limiter controls how frequently events are allowed to happen.
 type Limiter struct {
-	rdb          rueidis.Client
-	limit        Limit
-	customLimits *haxmap.Map[string, Limit]
-	prefix       string
+	rdb               rueidis.Client
+	limit             Limit
+	customLimits      LimitStore
+	policies          *haxmap.Map[string, KeyPolicy]
+	algorithm         Algorithm
+	prefix            string
+	defaultN          int
+	failureMode       FailureMode
+	breaker           *breaker
+	resultPool        *sync.Pool
+	argsCache         *limitArgsCache
+	auditSink         func(ctx context.Context, entry AuditEntry)
+	metadataExtractor func(ctx context.Context) map[string]string
+	now               func() time.Time
+	schedule          []ScheduledLimit
+	keyEncoding       KeyEncoding
+	waiters           *waiters
+	sentinel          Sentinel
+	hedgeDelay        time.Duration
+	debugRaw          bool
+	ttlMargin         time.Duration
+	maxN              int
+	stats             *stats
+	enabled           *atomic.Bool
+	windowBuckets     string
+	minRetryAfter     time.Duration
+	functions         *functionMode
+	keyValidator      func(key string) error
+	generations       bool
+	shadow            *shadowReplayer
+	countDenials      bool
+	threshold         float64
+	thresholdCallback func(key string, res *Result)
+	legacyPeriod      bool
+	lazyTTL           time.Duration
+	bypass            func(key string) bool
+	errorOnDeny       bool
+	remoteSchedule    *remoteSchedule
+	sequences         bool
+	penalty           *penalty
+	trackCardinality  bool
+	alignedWindows    bool
+	deniedHook        func(ctx context.Context, key string, n int, res *Result)
+	exposeTAT         bool
+	positionalResults bool
+
+	shareCustomLimits bool
 }
 
 type LimiterOption func(*Limiter)
 
 func WithCustomLimits(limits *haxmap.Map[string, Limit]) LimiterOption {
 	return func(l *Limiter) {
-		l.customLimits = limits
+		if limits == nil {
+			// NewLimiter falls back to an empty store
+			l.customLimits = nil
+			return
+		}
+		l.customLimits = haxmapStore{m: limits}
 	}
 }
 
@@ -100,6 +184,144 @@ func WithPrefix(prefix string) LimiterOption {
 	}
 }
 
+// WithDefaultN sets the number of events consumed by Allow. It panics if n is
+// less than 1.
+func WithDefaultN(n int) LimiterOption {
+	if n < 1 {
+		panic("rate_limiter: default n must be at least 1")
+	}
+	return func(l *Limiter) {
+		l.defaultN = n
+	}
+}
+
+// WithResultPool makes the limiter reuse Results from a sync.Pool. Callers
+// must call Result.Release once they are done with a Result and must not use
+// it afterwards.
+func WithResultPool() LimiterOption {
+	return func(l *Limiter) {
+		l.resultPool = &sync.Pool{
+			New: func() any { return new(Result) },
+		}
+	}
+}
+
+// WithLazyTTL makes AllowN with AlgoGCRA keep a key's expiry as long as it
+// still outlives the key's state, and otherwise extend it by refreshEvery
+// beyond what is needed, so that hot keys don't have their expiry rewritten
+// on every call. It requires Redis 6 or later. It panics if refreshEvery is
+// not positive.
+func WithLazyTTL(refreshEvery time.Duration) LimiterOption {
+	if refreshEvery <= 0 {
+		panic("rate_limiter: lazy TTL refresh interval must be positive")
+	}
+	return func(l *Limiter) {
+		l.lazyTTL = refreshEvery
+	}
+}
+
+// WithTTLMargin keeps the state of a key in Redis for margin after it has
+// fully refilled, which for AlgoFixedWindow is the end of its window. The
+// margin is added to the expiry the algorithm computes. Expired keys behave
+// exactly like fresh ones, so the margin only matters to tools that inspect
+// keys, such as TTL and Exists, at the cost of storing idle keys for longer.
+// AlgoFixedWindow reads the end of a window from the key's expiry, so
+// changing the margin moves the windows already started. Limit.ExtraTTL
+// overrides it for a limit.
+func WithTTLMargin(margin time.Duration) LimiterOption {
+	return func(l *Limiter) {
+		l.ttlMargin = margin
+	}
+}
+
+// ErrNExceedsMax is returned when more events than the maximum set by
+// WithMaxN are requested in a single call.
+var ErrNExceedsMax = errors.New("rate_limiter: n exceeds the maximum")
+
+// WithMaxN rejects calls consuming more than max events at once with
+// ErrNExceedsMax. By default n is unlimited, as it is for a max of 0.
+func WithMaxN(max int) LimiterOption {
+	return func(l *Limiter) {
+		l.maxN = max
+	}
+}
+
+// WithBypass sets a function selecting keys that are never limited, such as
+// those of internal services. AllowN and AllowMany allow every event for them
+// without reaching Redis.
+func WithBypass(bypass func(key string) bool) LimiterOption {
+	return func(l *Limiter) {
+		l.bypass = bypass
+	}
+}
+
+// ErrRateLimited is returned along with the Result by AllowN and Allow for
+// denied events when the limiter is created WithErrorOnDeny.
+var ErrRateLimited = errors.New("rate_limiter: rate limited")
+
+// WithErrorOnDeny makes AllowN and Allow return ErrRateLimited along with the
+// Result when the events are denied, including by the failure mode, so that
+// denials can be told apart with errors.Is. The Result is still returned.
+func WithErrorOnDeny() LimiterOption {
+	return func(l *Limiter) {
+		l.errorOnDeny = true
+	}
+}
+
+// WithEnabled sets whether the limiter starts out enabled. See SetEnabled.
+func WithEnabled(enabled bool) LimiterOption {
+	return func(l *Limiter) {
+		l.enabled.Store(enabled)
+	}
+}
+
+// SetEnabled turns limiting on or off at runtime. While disabled, consuming
+// calls allow every event without calling Redis. It is safe to call
+// concurrently with other methods.
+func (l *Limiter) SetEnabled(enabled bool) {
+	l.enabled.Store(enabled)
+}
+
+// WithMinRetryAfter raises any positive RetryAfter shorter than d to d, so
+// that clients don't retry in a tight loop.
+func WithMinRetryAfter(d time.Duration) LimiterOption {
+	return func(l *Limiter) {
+		l.minRetryAfter = d
+	}
+}
+
+// WithExposeTAT sets Result.TAT for keys using AlgoGCRA, for example to
+// coordinate limits with another system implementing GCRA.
+func WithExposeTAT() LimiterOption {
+	return func(l *Limiter) {
+		l.exposeTAT = true
+	}
+}
+
+// applyAlgorithm sets the fields of a result from Redis that depend on the
+// algorithm of the key: Used, counted against the algorithm's capacity, and
+// TAT when WithExposeTAT is enabled. Scripts report the window start as the
+// TAT minus the period.
+func (l Limiter) applyAlgorithm(algorithm Algorithm, res *Result) {
+	if res == nil || !res.fromRedis {
+		return
+	}
+	policy := KeyPolicy{Limit: res.Limit, Algorithm: algorithm}
+	res.Used = max(policy.capacity()-res.Remaining, 0)
+	if !l.exposeTAT || algorithm != AlgoGCRA || res.WindowStart.IsZero() {
+		return
+	}
+	res.TAT = res.WindowStart.Add(res.Limit.Period)
+}
+
+// WithDebugRawResult sets Result.Raw to the values returned by the script in
+// the positional format, which helps when diagnosing script behavior.
+func WithDebugRawResult() LimiterOption {
+	return func(l *Limiter) {
+		l.debugRaw = true
+	}
+}
+
 func defaultLimits() Limit {
 	return Limit{
 		Burst:  1,
@@ -111,24 +333,75 @@ func defaultLimits() Limit {
 // NewLimiter returns a new Limiter.
 func NewLimiter(rdb rueidis.Client, opts ...LimiterOption) *Limiter {
 	limiter := &Limiter{
-		rdb:    rdb,
-		limit:  defaultLimits(),
-		prefix: redisPrefix,
+		rdb:           rdb,
+		limit:         defaultLimits(),
+		prefix:        redisPrefix,
+		defaultN:      1,
+		argsCache:     &limitArgsCache{},
+		now:           time.Now,
+		waiters:       &waiters{counts: make(map[string]int)},
+		stats:         &stats{},
+		enabled:       &atomic.Bool{},
+		windowBuckets: "1",
 	}
+	limiter.enabled.Store(true)
 	for _, opt := range opts {
 		opt(limiter)
 	}
 
-	if limiter.customLimits == nil {
-		limiter.customLimits = haxmap.New[string, Limit]()
+	limiter.setUp(nil)
+	return limiter
+}
+
+// setUp finishes setting up l once its options are applied. The breaker,
+// remote schedule and shadow limiter l shares with parent, if any, are
+// already set up and left as they are.
+func (l *Limiter) setUp(parent *Limiter) {
+	if parent == nil {
+		parent = &Limiter{}
+	}
+	if l.breaker != nil && l.breaker != parent.breaker {
+		l.breaker.now = l.now
 	}
 
-	return limiter
+	if l.customLimits == nil {
+		l.customLimits = newHaxmapStore()
+	}
+	if l.policies == nil {
+		l.policies = haxmap.New[string, KeyPolicy]()
+	}
+	if l.remoteSchedule != nil && l.remoteSchedule != parent.remoteSchedule {
+		go l.remoteSchedule.poll(l.rdb)
+	}
+	if l.shadow != nil && l.shadow != parent.shadow {
+		l.shadow.start()
+	}
 }
 
-// Allow is a shortcut for AllowN(ctx, key, limit, 1).
+// Allow is a shortcut for AllowN(ctx, key, n) where n is the default set by
+// WithDefaultN, 1 unless configured.
 func (l Limiter) Allow(ctx context.Context, key string) (*Result, error) {
-	return l.AllowN(ctx, key, 1)
+	return l.AllowN(ctx, key, l.defaultN)
+}
+
+// Check is like Allow but also reports directly whether the events were
+// allowed.
+func (l Limiter) Check(ctx context.Context, key string) (allowed bool, res *Result, err error) {
+	res, err = l.allowN(ctx, key, l.defaultN)
+	if err != nil {
+		return false, nil, err
+	}
+	return res.Allowed > 0, res, nil
+}
+
+// CallOption overrides the limiter's settings for a single call.
+type CallOption struct {
+	apply func(*callSettings)
+}
+
+// callSettings holds the settings CallOptions override.
+type callSettings struct {
+	failClosed bool
 }
 
 // AllowN reports whether n events may happen at time now.
@@ -136,82 +409,841 @@ func (l Limiter) AllowN(
 	ctx context.Context,
 	key string,
 	n int,
+	opts ...CallOption,
 ) (*Result, error) {
-	limit := l.limit
-	if cl, ok := l.customLimits.Get(key); ok {
-		limit = cl
+	var settings callSettings
+	for _, opt := range opts {
+		opt.apply(&settings)
 	}
-	values := []string{strconv.Itoa(limit.Burst),
-		strconv.Itoa(limit.Rate),
-		strconv.FormatFloat(limit.Period.Seconds(), 'f', 2, 32),
-		strconv.Itoa(n)}
-	result, err := allowN.Exec(ctx, l.rdb, []string{l.prefix + key}, values).AsFloatSlice()
-	if err != nil {
+	// l is a copy, so the settings only apply to this call
+	if settings.failClosed {
+		l.failureMode = FailClosed
+	}
+	res, err := l.allowN(ctx, key, n)
+	if err == nil && l.errorOnDeny && res.Reason != ReasonNone {
+		return res, ErrRateLimited
+	}
+	return res, err
+}
+
+// allowN is AllowN without the call options and WithErrorOnDeny, for callers
+// in the package that inspect the Result themselves.
+func (l Limiter) allowN(ctx context.Context, key string, n int) (*Result, error) {
+	if err := l.validateKeys(key); err != nil {
 		return nil, err
 	}
+	c := l.allowCall(key, n)
+	if l.bypass != nil && l.bypass(key) {
+		return l.allowedResult(c.limit, n), nil
+	}
+	res, err := l.consume(ctx, c)
+	return l.afterAllow(ctx, key, res, err)
+}
 
-	retryAfter := result[2]
-	resetAfter := result[3]
-	res := &Result{
-		Limit:      limit,
-		Allowed:    int(result[0]),
-		Remaining:  int(result[1]),
-		RetryAfter: dur(retryAfter),
-		ResetAfter: dur(resetAfter),
+// allowCall returns the call consuming n events from key with its policy,
+// which replays the call, tracks the key and applies its penalty when it
+// reaches Redis.
+func (l Limiter) allowCall(key string, n int) call {
+	policy := l.policyFor(key)
+	c := l.newCall(policy.Algorithm.scripts().allowN, key, policy.Limit, n)
+	c.args = l.algorithmArgs(policy.Algorithm)
+	c.algorithm = policy.Algorithm
+	if l.shadow != nil || l.trackCardinality || l.penalty != nil {
+		c.prepare = func(ctx context.Context, c *call) {
+			l.replay(ctx, key, n)
+			l.trackKey(ctx, key)
+			if l.penalty != nil && l.penalized(ctx, key) {
+				c.limit = l.penalty.limit
+			}
+		}
 	}
-	return res, nil
+	return c
+}
+
+// afterAllow records the penalty, denials, sequence and generation of the
+// result of an allowCall.
+func (l Limiter) afterAllow(ctx context.Context, key string, res *Result, err error) (*Result, error) {
+	res, err = l.withPenalty(ctx, key, res, err)
+	res, err = l.withDenials(ctx, key, res, err)
+	res, err = l.withSequence(ctx, key, res, err)
+	return l.withGeneration(ctx, key, res, err)
 }
 
 // AllowAtMost reports whether at most n events may happen at time now.
 // It returns number of allowed events that is less than or equal to n.
+// As with AllowN, an n of 0 inspects the key without consuming any events:
+// the Result allows nothing and reports the remaining events.
 func (l Limiter) AllowAtMost(
 	ctx context.Context,
 	key string,
 	limit Limit,
 	n int,
 ) (*Result, error) {
-	values := []string{strconv.Itoa(limit.Burst),
-		strconv.Itoa(limit.Rate),
-		strconv.FormatFloat(limit.Period.Seconds(), 'f', 2, 32),
-		strconv.Itoa(n)}
-	result, err := allowAtMost.Exec(ctx, l.rdb, []string{l.prefix + key}, values).AsFloatSlice()
+	if err := l.validateKeys(key); err != nil {
+		return nil, err
+	}
+	if _, err := l.gcraLimitFor(key); err != nil {
+		return nil, err
+	}
+	res, err := l.consume(ctx, l.newCall(allowAtMost, key, limit, n))
+	res, err = l.withDenials(ctx, key, res, err)
+	res, err = l.withSequence(ctx, key, res, err)
+	return l.withGeneration(ctx, key, res, err)
+}
+
+// AllowIfRemaining is like AllowN but only consumes the events if at least
+// minRemaining events would remain afterwards, so that the capacity below
+// minRemaining is kept for other callers. The check and the consumption are
+// atomic. Denied results report the unchanged remaining events.
+func (l Limiter) AllowIfRemaining(ctx context.Context, key string, n, minRemaining int) (*Result, error) {
+	if err := l.validateKeys(key); err != nil {
+		return nil, err
+	}
+	limit, err := l.gcraLimitFor(key)
 	if err != nil {
 		return nil, err
 	}
+	c := l.newCall(allowIfRemaining, key, limit, n)
+	c.args = []string{strconv.Itoa(max(minRemaining, 0))}
+	res, err := l.consume(ctx, c)
+	res, err = l.withDenials(ctx, key, res, err)
+	res, err = l.withSequence(ctx, key, res, err)
+	return l.withGeneration(ctx, key, res, err)
+}
 
-	retryAfter := result[2]
-	resetAfter := result[3]
+// call describes a single execution of a consuming script.
+type call struct {
+	script *script
+	// key is the key as given by the caller.
+	key string
+	// keys are the Redis keys passed to the script.
+	keys  []string
+	limit Limit
+	n     int
+	// args are passed to the script after the limit, n and the TTL margin.
+	args      []string
+	algorithm Algorithm
+	// prepare, if set, runs right before the script once the call is known
+	// to reach Redis, for lookups that may change the call.
+	prepare func(ctx context.Context, c *call)
+	// decided, if set, runs once the script returned, for scripts that pick
+	// which of their keys decided the call. The hooks and the audit sink are
+	// given the call as decided changed it.
+	decided func(c *call, res *Result)
+}
 
-	res := &Result{
-		Limit:      limit,
-		Allowed:    int(result[0]),
-		Remaining:  int(result[1]),
-		RetryAfter: dur(retryAfter),
-		ResetAfter: dur(resetAfter),
+func (l Limiter) newCall(script *script, key string, limit Limit, n int) call {
+	return call{
+		script: script,
+		key:    key,
+		keys:   []string{l.redisKey(key)},
+		limit:  limit,
+		n:      n,
 	}
-	return res, nil
+}
+
+// consume executes c and reports the decision to the audit sink. A context
+// that is already done fails the call before anything is sent, regardless of
+// the failure mode.
+func (l Limiter) consume(ctx context.Context, c call) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if l.maxN > 0 && c.n > l.maxN {
+		return nil, ErrNExceedsMax
+	}
+	res, err := l.exec(ctx, c)
+	if res != nil {
+		if c.decided != nil {
+			c.decided(&c, res)
+		}
+		res.Rejected = max(c.n-res.Allowed, 0)
+	}
+	l.applyAlgorithm(c.algorithm, res)
+	l.checkSoftLimit(c, res)
+	l.checkThreshold(c, res)
+	l.audit(ctx, c, res)
+	return res, err
+}
+
+// audit reports the decision made for c to the audit sink and, if it was a
+// denial, to the denied hook.
+func (l Limiter) audit(ctx context.Context, c call, res *Result) {
+	if res == nil {
+		return
+	}
+	if l.deniedHook != nil && res.Reason == ReasonLimitExceeded {
+		l.deniedHook(ctx, c.key, c.n, res)
+	}
+	if l.auditSink == nil {
+		return
+	}
+	entry := AuditEntry{
+		Key:    c.key,
+		N:      c.n,
+		Result: res,
+		Time:   l.now(),
+	}
+	if l.metadataExtractor != nil {
+		entry.Metadata = l.metadataExtractor(ctx)
+	}
+	l.auditSink(ctx, entry)
+}
+
+func (l Limiter) exec(ctx context.Context, c call) (*Result, error) {
+	if !l.enabled.Load() {
+		return l.allowedResult(c.limit, c.n), nil
+	}
+	if c.limit.Burst == 0 {
+		return l.blockedResult(c.limit), nil
+	}
+	if !l.breaker.allow() {
+		return l.failResult(c.limit, c.n, ErrCircuitOpen)
+	}
+	if c.prepare != nil {
+		c.prepare(ctx, &c)
+		if c.limit.Burst == 0 {
+			// only a penalty read from Redis blocks the call here, so
+			// Redis answered and a half-open probe succeeded
+			l.breaker.record(nil)
+			return l.blockedResult(c.limit), nil
+		}
+	}
+	v := l.limitValues(c.limit, c.n).add(c.args...)
+	result, err := scriptResult(l.eval(ctx, c.script, c.keys, *v))
+	v.release()
+	l.breaker.record(err)
+	if err != nil {
+		return l.failResult(c.limit, c.n, err)
+	}
+	return l.newResult(c.limit, c.n, result)
+}
+
+// Charge consumes cost events for key even if that takes it over its limit,
+// which is useful when the cost is only known after the fact. Events charged
+// beyond the limit are reported as Result.Debt and later requests are denied
+// until they have been refilled.
+func (l Limiter) Charge(ctx context.Context, key string, cost int) (*Result, error) {
+	if err := l.validateKeys(key); err != nil {
+		return nil, err
+	}
+	limit, err := l.gcraLimitFor(key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := l.consume(ctx, l.newCall(charge, key, limit, cost))
+	res, err = l.withDenials(ctx, key, res, err)
+	res, err = l.withSequence(ctx, key, res, err)
+	return l.withGeneration(ctx, key, res, err)
+}
+
+// Peek reports the current state of key without consuming any events.
+func (l Limiter) Peek(ctx context.Context, key string) (*Result, error) {
+	if err := l.validateKeys(key); err != nil {
+		return nil, err
+	}
+	policy := l.policyFor(key)
+	limit := policy.Limit
+	if limit.Burst == 0 {
+		return l.blockedResult(limit), nil
+	}
+	values := append(l.limitArgs(limit, 0), l.algorithmArgs(policy.Algorithm)...)
+	res, err := hedge(ctx, l.hedgeDelay, func(ctx context.Context) (*Result, error) {
+		result, err := scriptResult(l.eval(ctx, policy.Algorithm.scripts().peek, []string{l.redisKey(key)}, values))
+		if err != nil {
+			return nil, err
+		}
+		return l.newResult(limit, 0, result)
+	})
+	if err == nil {
+		l.applyAlgorithm(policy.Algorithm, res)
+	}
+	return l.withGeneration(ctx, key, res, err)
+}
+
+// MaxAllowed returns the largest n for which AllowN(ctx, key, n) would
+// currently be allowed, without consuming any events.
+func (l Limiter) MaxAllowed(ctx context.Context, key string) (int, error) {
+	res, err := l.Peek(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Release()
+	return res.Remaining, nil
+}
+
+// IsLimited reports whether AllowN(ctx, key, 1) would currently be denied,
+// without consuming any events.
+func (l Limiter) IsLimited(ctx context.Context, key string) (bool, error) {
+	n, err := l.MaxAllowed(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return n < 1, nil
+}
+
+// PeekMany is like Peek for several keys at once. The peeks are pipelined
+// and the results are returned in the same order as keys. If some keys
+// fail, the error is a KeyErrors and the results of the other keys are
+// still returned.
+func (l Limiter) PeekMany(ctx context.Context, keys []string) ([]*Result, error) {
+	results := make([]*Result, len(keys))
+	errs := make(KeyErrors, len(keys))
+	policies := make([]KeyPolicy, len(keys))
+	// keys are grouped by peek script, one pipeline per algorithm in use
+	groups := make(map[*script][]int)
+	for i, key := range keys {
+		if errs[i] = l.validateKeys(key); errs[i] != nil {
+			continue
+		}
+		policy := l.policyFor(key)
+		policies[i] = policy
+		if policy.Burst == 0 {
+			results[i] = l.blockedResult(policy.Limit)
+			continue
+		}
+		s := policy.Algorithm.scripts().peek
+		groups[s] = append(groups[s], i)
+	}
+	for s, indexes := range groups {
+		execs := make([]rueidis.LuaExec, len(indexes))
+		for j, i := range indexes {
+			execs[j] = rueidis.LuaExec{
+				Keys: []string{l.redisKey(keys[i])},
+				Args: append(l.limitArgs(policies[i].Limit, 0), l.algorithmArgs(policies[i].Algorithm)...),
+			}
+		}
+		for j, resp := range l.evalMulti(ctx, s, execs) {
+			i := indexes[j]
+			result, err := scriptResult(resp)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			results[i], errs[i] = l.newResult(policies[i].Limit, 0, result)
+			l.applyAlgorithm(policies[i].Algorithm, results[i])
+		}
+	}
+	return results, errs.err()
+}
+
+// Ping checks that Redis can be reached with the limiter's client, for
+// example for readiness checks.
+func (l Limiter) Ping(ctx context.Context) error {
+	return l.rdb.Do(ctx, l.rdb.B().Ping().Build()).Error()
 }
 
 // Reset gets a key and reset all limitations and previous usages
 func (l *Limiter) Reset(ctx context.Context, key string) error {
-	cmd := l.rdb.B().Del().Key(l.prefix + key).Build()
-	return l.rdb.Do(ctx, cmd).Error()
+	if err := l.validateKeys(key); err != nil {
+		return err
+	}
+	_, err := l.reset(ctx, key)
+	return err
+}
+
+// ResetIfIdle is like Reset but only resets key if it wasn't used for at
+// least idle, so that keys in active use keep their state. It reports
+// whether key was reset. Idleness is read from Redis with OBJECT IDLETIME, so
+// it has a resolution of one second, counts Peek as a use and isn't
+// available when Redis evicts keys with an LFU policy.
+func (l *Limiter) ResetIfIdle(ctx context.Context, key string, idle time.Duration) (bool, error) {
+	if err := l.validateKeys(key); err != nil {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	args := []string{strconv.FormatInt(idle.Milliseconds(), 10)}
+	reset, err := l.eval(ctx, resetIfIdle, []string{l.redisKey(key)}, args).AsBool()
+	if err != nil || !reset {
+		return false, err
+	}
+	if _, err := l.resetExtras(ctx, key); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// ResetR is like Reset but also returns the state of key after the reset,
+// which always has the full capacity remaining.
+func (l *Limiter) ResetR(ctx context.Context, key string) (*Result, error) {
+	if err := l.validateKeys(key); err != nil {
+		return nil, err
+	}
+	gen, err := l.reset(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	policy := l.policyFor(key)
+	if policy.Burst == 0 {
+		res := l.blockedResult(policy.Limit)
+		res.Generation = gen
+		return res, nil
+	}
+	return &Result{
+		Limit:      policy.Limit,
+		Remaining:  policy.capacity(),
+		RetryAfter: l.sentinel.value(),
+		Generation: gen,
+	}, nil
+}
+
+// Refund returns n events to key, for example when an allowed operation ended
+// up not being performed. A key can't be refunded beyond its full capacity.
+func (l *Limiter) Refund(ctx context.Context, key string, n int) error {
+	if err := l.validateKeys(key); err != nil {
+		return err
+	}
+	policy := l.policyFor(key)
+	v := l.limitValues(policy.Limit, n)
+	defer v.release()
+	return l.eval(ctx, policy.Algorithm.scripts().refund, []string{l.redisKey(key)}, *v).Error()
+}
+
+// RefundMany is like Refund for several keys at once, pipelining the
+// refunds. It returns the first error encountered.
+func (l *Limiter) RefundMany(ctx context.Context, refunds map[string]int) error {
+	if len(refunds) == 0 {
+		return nil
+	}
+	// keys are grouped by refund script, one pipeline per algorithm in use
+	groups := make(map[*script][]rueidis.LuaExec)
+	for key, n := range refunds {
+		if err := l.validateKeys(key); err != nil {
+			return err
+		}
+		policy := l.policyFor(key)
+		s := policy.Algorithm.scripts().refund
+		groups[s] = append(groups[s], rueidis.LuaExec{
+			Keys: []string{l.redisKey(key)},
+			Args: l.limitArgs(policy.Limit, n),
+		})
+	}
+	for s, execs := range groups {
+		for _, resp := range l.evalMulti(ctx, s, execs) {
+			if err := resp.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RefillBurst restores the full burst for key by moving its theoretical
+// arrival time forward to now. Unlike Reset the key is not deleted and keeps
+// its current expiry.
+func (l *Limiter) RefillBurst(ctx context.Context, key string) error {
+	if err := l.validateKeys(key); err != nil {
+		return err
+	}
+	if _, err := l.gcraLimitFor(key); err != nil {
+		return err
+	}
+	return l.eval(ctx, refillBurst, []string{l.redisKey(key)}, nil).Error()
+}
+
+// RampReset restores the full burst for key gradually instead of at once, so
+// that it is back to its initial state after at most over. Until then the
+// remaining events are capped by a ramp growing linearly from the events left
+// now to the full burst, which keeps a key that was blocked from having its
+// whole burst available immediately. The ramp is stored with the key. Keys
+// that refill sooner on their own are left as they are.
+func (l *Limiter) RampReset(ctx context.Context, key string, over time.Duration) error {
+	if err := l.validateKeys(key); err != nil {
+		return err
+	}
+	limit, err := l.gcraLimitFor(key)
+	if err != nil {
+		return err
+	}
+	v := l.limitValues(limit, 0).add(strconv.FormatInt(int64(max(over, 0)), 10))
+	defer v.release()
+	return l.eval(ctx, rampReset, []string{l.redisKey(key)}, *v).Error()
+}
+
+// TransferQuota moves the usage recorded for from onto to, keeping whichever
+// of the two is more restrictive, and deletes from. Both keys must hash to
+// the same Redis Cluster slot, for example by sharing a hash tag, or
+// ErrCrossSlot is returned.
+func (l *Limiter) TransferQuota(ctx context.Context, from, to string) error {
+	if err := l.validateKeys(from, to); err != nil {
+		return err
+	}
+	for _, key := range []string{from, to} {
+		if _, err := l.gcraLimitFor(key); err != nil {
+			return err
+		}
+	}
+	keys := []string{l.redisKey(from), l.redisKey(to)}
+	if err := checkSlots(keys); err != nil {
+		return err
+	}
+	return l.eval(ctx, transferQuota, keys, nil).Error()
+}
+
+// limitFor returns the custom limit configured for key, falling back to the
+// active scheduled limit and then to the limiter's default limit.
+func (l Limiter) limitFor(key string) Limit {
+	if cl, ok := l.customLimits.Get(key); ok {
+		return cl
+	}
+	if sl, ok := l.scheduledLimit(); ok {
+		return sl
+	}
+	return l.limit
+}
+
+// allowedResult is returned when events are allowed without consulting
+// Redis.
+func (l Limiter) allowedResult(limit Limit, n int) *Result {
+	return &Result{
+		Limit:      limit,
+		Allowed:    n,
+		Remaining:  limit.Burst,
+		RetryAfter: l.sentinel.value(),
+	}
+}
+
+// blockedResult is returned for limits with a zero burst, which deny every
+// event without consulting Redis.
+func (l Limiter) blockedResult(limit Limit) *Result {
+	return &Result{
+		Limit:      limit,
+		RetryAfter: l.sentinel.value(),
+		ResetAfter: l.sentinel.value(),
+		Reason:     ReasonBlocked,
+	}
+}
+
+// limitArgs returns the script arguments for limit and n.
+func (l Limiter) limitArgs(limit Limit, n int) []string {
+	return l.appendLimitArgs(make([]string, 0, 5), limit, n)
+}
+
+// appendLimitArgs appends the script arguments for limit and n to dst. The
+// formatted limit is cached since a key's limit rarely changes between calls.
+func (l Limiter) appendLimitArgs(dst []string, limit Limit, n int) []string {
+	args, ok := l.argsCache.get(limit)
+	if !ok {
+		args = l.formatLimit(limit)
+		l.argsCache.add(limit, args)
+	}
+	return append(dst, args[0], args[1], args[2], strconv.Itoa(n),
+		strconv.FormatInt(l.ttlMarginFor(limit).Milliseconds(), 10))
+}
+
+// maxCachedLimits is how many formatted limits a limiter caches. Limits seen
+// once the cache is full, such as many distinct limits passed to
+// AllowAtMost, are formatted on every call.
+const maxCachedLimits = 1024
+
+type limitArgsCache struct {
+	m    sync.Map
+	size atomic.Int64
+}
+
+func (c *limitArgsCache) get(limit Limit) ([3]string, bool) {
+	v, ok := c.m.Load(limit)
+	if !ok {
+		return [3]string{}, false
+	}
+	return v.([3]string), true
+}
+
+func (c *limitArgsCache) add(limit Limit, args [3]string) {
+	if c.size.Load() >= maxCachedLimits {
+		return
+	}
+	if _, loaded := c.m.LoadOrStore(limit, args); !loaded {
+		c.size.Add(1)
+	}
+}
+
+// ttlMarginFor returns how long the state of a key with limit is kept after
+// it has fully refilled.
+func (l Limiter) ttlMarginFor(limit Limit) time.Duration {
+	if limit.ExtraTTL > 0 {
+		return limit.ExtraTTL
+	}
+	return l.ttlMargin
+}
+
+func (l Limiter) formatLimit(limit Limit) [3]string {
+	return [3]string{strconv.Itoa(limit.Burst),
+		strconv.Itoa(limit.Rate),
+		l.formatPeriod(limit.Period)}
+}
+
+// formatPeriod formats period in integer nanoseconds, first rounding it to
+// hundredths of a second at float32 precision under
+// WithLegacyPeriodEncoding.
+func (l Limiter) formatPeriod(period time.Duration) string {
+	if l.legacyPeriod {
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(period.Seconds(), 'f', 2, 32), 64)
+		period = time.Duration(math.Round(f * float64(time.Second)))
+	}
+	return strconv.FormatInt(int64(period), 10)
+}
+
+// WithLegacyPeriodEncoding rounds periods to hundredths of a second before
+// passing them to the scripts, as older versions did. Periods such as 333ms
+// are then enforced as 330ms. It is meant as a migration aid only.
+func WithLegacyPeriodEncoding() LimiterOption {
+	return func(l *Limiter) {
+		l.legacyPeriod = true
+	}
+}
+
+// Status codes a script may return as the first element of a result with
+// six or more elements.
+const (
+	statusOK = iota
+	statusDenied
+	statusInvalid
+)
+
+// ErrInvalidRequest is returned when a script rejects its arguments.
+var ErrInvalidRequest = errors.New("rate_limiter: invalid request")
+
+// newResult decodes the values returned by a script for a call consuming n
+// events, applying the limiter's settings on top of decodeResult.
+func (l Limiter) newResult(limit Limit, n int, result []float64) (*Result, error) {
+	var res *Result
+	var err error
+	if l.resultPool != nil {
+		res = l.resultPool.Get().(*Result)
+		if err = decodeInto(res, limit, result); err != nil {
+			l.resultPool.Put(res)
+			return nil, err
+		}
+		res.pool = l.resultPool
+	} else if res, err = decodeResult(limit, result); err != nil {
+		return nil, err
+	}
+	res.fromRedis = true
+	if res.RetryAfter == -1 {
+		res.RetryAfter = l.sentinel.value()
+	}
+	if res.ResetAfter == -1 {
+		res.ResetAfter = l.sentinel.value()
+	}
+	if res.RetryAfter > 0 && res.RetryAfter < l.minRetryAfter {
+		res.RetryAfter = l.minRetryAfter
+	}
+	// results in the older format have no status to tell denials apart
+	if res.Allowed == 0 && n > 0 {
+		res.Reason = ReasonLimitExceeded
+	}
+	if l.debugRaw {
+		res.Raw = result
+	}
+	return res, nil
+}
+
+// resultFields are the fields a script may return, in the order of the
+// positional format.
+var resultFields = []string{"status", "allowed", "remaining", "retry_after",
+	"reset_after", "previous", "window_start", "level", "first_seen"}
+
+// scriptResult returns the values of a script's reply in the positional
+// format read by decodeResult. Scripts return flat arrays of field names and
+// values, read by name with defaults for missing fields and unknown fields
+// ignored. Replies already in the positional format are returned as they
+// are.
+func scriptResult(resp rueidis.RedisResult) ([]float64, error) {
+	values, err := resp.ToArray()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 || !values[0].IsString() {
+		return resp.AsFloatSlice()
+	}
+	return namedResult(values)
+}
+
+func namedResult(values []rueidis.RedisMessage) ([]float64, error) {
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("rate_limiter: unexpected script result of length %d", len(values))
+	}
+	result := []float64{statusOK, 0, 0, -1, -1, math.NaN(), 0, 0, 0}
+	var tiers []float64
+	for i := 0; i < len(values); i += 2 {
+		name, err := values[i].ToString()
+		if err != nil {
+			return nil, err
+		}
+		if name == "tiers" {
+			if tiers, err = values[i+1].AsFloatSlice(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		j := slices.Index(resultFields, name)
+		if j < 0 {
+			continue
+		}
+		if result[j], err = messageFloat(values[i+1]); err != nil {
+			return nil, fmt.Errorf("rate_limiter: bad script result field %q: %w", name, err)
+		}
+	}
+	// previous defaults to the remaining events, as for peeks
+	if math.IsNaN(result[5]) {
+		result[5] = result[2]
+	}
+	return append(result, tiers...), nil
+}
+
+func messageFloat(m rueidis.RedisMessage) (float64, error) {
+	if m.IsInt64() {
+		n, err := m.ToInt64()
+		return float64(n), err
+	}
+	return m.AsFloat64()
+}
+
+// decodeResult decodes the values returned by a script using nothing but its
+// arguments. Durations that don't apply are reported as -1. Scripts return
+// {status, allowed, remaining, retry_after, reset_after, previous,
+// window_start}, optionally followed by the described key's level for
+// scripts covering several keys, 0 otherwise, whether the key was just
+// created and the {remaining, reset_after} pairs of every key covered.
+// Results of four or five elements are the older format {allowed,
+// remaining, retry_after, reset_after[, previous]} without a status.
+func decodeResult(limit Limit, raw []float64) (*Result, error) {
+	res := &Result{}
+	if err := decodeInto(res, limit, raw); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeInto is decodeResult writing into res. res is left untouched if raw
+// can't be decoded.
+func decodeInto(res *Result, limit Limit, raw []float64) error {
+	result := raw
+	status := statusOK
+	if len(result) >= 6 {
+		status = int(result[0])
+		result = result[1:]
+	}
+	if len(result) < 4 {
+		return fmt.Errorf("rate_limiter: unexpected script result of length %d", len(raw))
+	}
+	switch status {
+	case statusOK, statusDenied:
+	case statusInvalid:
+		return ErrInvalidRequest
+	default:
+		return fmt.Errorf("rate_limiter: unknown script status %d", status)
+	}
+
+	res.Limit = limit
+	res.Allowed = int(result[0])
+	res.Remaining = int(result[1])
+	res.RetryAfter = SentinelNegativeOne.dur(result[2])
+	res.ResetAfter = SentinelNegativeOne.dur(result[3])
+	if len(result) > 4 {
+		res.PreviousRemaining = max(int(result[4]), 0)
+	}
+	if len(result) > 5 {
+		res.WindowStart = time.Unix(0, int64(result[5]*float64(time.Second)))
+	}
+	if len(result) > 6 {
+		res.level = int(result[6])
+	}
+	if len(result) > 7 {
+		res.FirstSeen = result[7] == 1
+	}
+	if len(result) > 8 {
+		res.tiers = result[8:]
+	}
+	if res.Remaining < 0 {
+		res.Debt = -res.Remaining
+		res.Remaining = 0
+	}
+	if status == statusDenied {
+		res.Reason = ReasonLimitExceeded
+	}
+	return nil
+}
+
+// Sentinel is the duration reported by Result.RetryAfter and
+// Result.ResetAfter when they don't apply.
+type Sentinel int
+
+const (
+	// SentinelNegativeOne reports durations that don't apply as -1. This
+	// is the default.
+	SentinelNegativeOne Sentinel = iota
+	// SentinelZero reports durations that don't apply as 0.
+	SentinelZero
+)
+
+// WithSentinel sets the duration reported when RetryAfter or ResetAfter
+// don't apply.
+func WithSentinel(s Sentinel) LimiterOption {
+	return func(l *Limiter) {
+		l.sentinel = s
+	}
+}
+
+func (s Sentinel) value() time.Duration {
+	if s == SentinelZero {
+		return 0
+	}
+	return -1
 }
 
-func dur(f float64) time.Duration {
+func (s Sentinel) dur(f float64) time.Duration {
 	if f == -1 {
-		return -1
+		return s.value()
 	}
 	return time.Duration(f * float64(time.Second))
 }
 
+// Reason describes why a Result denied events.
+type Reason int
+
+const (
+	// ReasonNone means the events were not denied.
+	ReasonNone Reason = iota
+	// ReasonLimitExceeded means the key has used up its limit.
+	ReasonLimitExceeded
+	// ReasonBlocked means the key's limit has a zero burst.
+	ReasonBlocked
+	// ReasonUnavailable means Redis could not be used and the limiter
+	// failed closed.
+	ReasonUnavailable
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonNone:
+		return "none"
+	case ReasonLimitExceeded:
+		return "limit exceeded"
+	case ReasonBlocked:
+		return "blocked"
+	case ReasonUnavailable:
+		return "unavailable"
+	}
+	return "Reason(" + strconv.Itoa(int(r)) + ")"
+}
+
 type Result struct {
-	// Limit is the limit that was used to obtain this result.
+	// Limit is the limit that was used to obtain this result. It is set on
+	// every Result, including those made up by the failure mode or while
+	// the limiter is disabled.
 	Limit Limit
 
 	// Allowed is the number of events that may happen at time now.
 	Allowed int
 
+	// Rejected is the number of requested events that were not allowed. It
+	// is only set by calls that consume events.
+	Rejected int
+
 	// Remaining is the maximum number of requests that could be
 	// permitted instantaneously for this key given the current
 	// state. For example, if a rate limiter allows 10 requests per
@@ -219,8 +1251,29 @@ type Result struct {
 	// second, Remaining would be 4.
 	Remaining int
 
+	// Used is the number of events used from the key's capacity: Burst
+	// minus Remaining, or Rate minus Remaining for AlgoFixedWindow,
+	// AlgoSlidingLog and AlgoSlidingWindow. It is zero for results that
+	// didn't come from Redis.
+	Used int
+
+	// PreviousRemaining is the value of Remaining just before this call
+	// consumed any events, so PreviousRemaining - Remaining == Allowed for
+	// allowed calls. When a call is denied it holds the capacity that was
+	// too small to satisfy the request.
+	PreviousRemaining int
+
+	// OverSoftLimit reports whether more events than the limit's SoftLimit
+	// are used. It is only set by calls that consume events.
+	OverSoftLimit bool
+
+	// Debt is the number of events charged beyond the limit by Charge that
+	// have yet to be refilled. Requests are denied while it is positive.
+	Debt int
+
 	// RetryAfter is the time until the next request will be permitted.
-	// It should be -1 unless the rate limit has been exceeded.
+	// It should be -1 (or 0 with SentinelZero) unless the rate limit has
+	// been exceeded.
 	RetryAfter time.Duration
 
 	// ResetAfter is the time until the RateLimiter returns to its
@@ -229,4 +1282,96 @@ type Result struct {
 	// Reset would return 800ms. You can also think of this as the time
 	// until Limit and Remaining will be equal.
 	ResetAfter time.Duration
+
+	// WindowStart is when the current window began, so that WindowStart
+	// plus the limit's Period is when the key is back to its initial state.
+	// It is zero for results that didn't come from Redis.
+	WindowStart time.Time
+
+	// FirstSeen reports whether no state was stored for the key before this
+	// call, because it was never used, was reset or expired. It is only set
+	// by calls that consume events.
+	FirstSeen bool
+
+	// Reason explains why the events were denied. It is ReasonNone when
+	// nothing was denied.
+	Reason Reason
+
+	// Raw holds the values returned by the script before decoding, in the
+	// positional format. It is only set when the limiter is created
+	// WithDebugRawResult.
+	Raw []float64
+
+	// TAT is the theoretical arrival time of the key's GCRA state, after
+	// which it is back to its full burst. It is only set for AlgoGCRA when
+	// the limiter is created WithExposeTAT.
+	TAT time.Time
+
+	// DeniedKey is the key that denied the events in a call covering
+	// several keys, such as AllowHierarchy.
+	DeniedKey string
+
+	// Generation increases every time the key is reset. It is 0 until the
+	// key is first reset and once the key was left unused long enough to
+	// expire. It is only set when the limiter is created
+	// WithResetGeneration.
+	Generation int64
+
+	// Sequence numbers the allowed calls of the key. It is only set when
+	// the limiter is created WithSequence.
+	Sequence int64
+
+	// ConsecutiveDenials is the number of calls in a row, including this
+	// one, that were denied for the key. It is only set when the limiter is
+	// created WithConsecutiveDenials.
+	ConsecutiveDenials int
+
+	// Tiers holds the state of every key of an AllowHierarchy call, in the
+	// order the keys were given.
+	Tiers []TierResult
+
+	// level is the 1-based index of the key this result describes in a
+	// call covering several keys.
+	level int
+
+	// tiers holds the undecoded {remaining, reset_after} pairs of every key
+	// in a call covering several keys.
+	tiers []float64
+
+	// fromRedis reports whether the result was decoded from a script, as
+	// opposed to made up by the limiter, such as by the failure mode.
+	fromRedis bool
+
+	pool *sync.Pool
+}
+
+// LimitString describes the limit that was used to obtain r.
+func (r *Result) LimitString() string {
+	return r.Limit.String()
+}
+
+// Release returns r to the limiter's pool when WithResultPool is enabled and
+// is a no-op otherwise. r, including its fields, must not be used after
+// Release, and Release must be called at most once per Result.
+func (r *Result) Release() {
+	pool := r.pool
+	if pool == nil {
+		return
+	}
+	*r = Result{}
+	pool.Put(r)
+}
+
+// AllowedBefore estimates how many events could be permitted between now and
+// deadline: the events remaining now plus those refilled at the limit's rate
+// until deadline.
+func (r *Result) AllowedBefore(deadline time.Time, now time.Time) int {
+	d := deadline.Sub(now)
+	if d < 0 || r.Limit.Burst == 0 {
+		return 0
+	}
+	if r.Limit.Rate <= 0 || r.Limit.Period <= 0 {
+		return r.Remaining
+	}
+	return r.Remaining + int(d/max(interval(r.Limit), 1))
 }
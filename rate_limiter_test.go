@@ -0,0 +1,993 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/alphadose/haxmap"
+	"github.com/redis/rueidis"
+	"golang.org/x/time/rate"
+)
+
+// newTestLimiter returns a limiter backed by an in-memory Redis that is
+// closed along with the test.
+func newTestLimiter(t testing.TB, opts ...LimiterOption) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	// miniredis has no ROLE, which scans use to skip replicas
+	mr.Server().Register("ROLE", func(c *server.Peer, cmd string, args []string) {
+		c.WriteLen(1)
+		c.WriteBulk("master")
+	})
+	rdb, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:  []string{mr.Addr()},
+		DisableCache: true,
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rdb.Close)
+	return NewLimiter(rdb, opts...), mr
+}
+
+func TestAllowN(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(3)))
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		res, err := l.AllowN(ctx, "k", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != 1 || res.Remaining != 2-i {
+			t.Fatalf("call %d: allowed %d, remaining %d", i, res.Allowed, res.Remaining)
+		}
+	}
+	res, err := l.AllowN(ctx, "k", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed != 0 || res.Reason != ReasonLimitExceeded || res.RetryAfter <= 0 {
+		t.Fatalf("got %+v, want a denial with a retry time", res)
+	}
+}
+
+func TestDefaultN(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(10)), WithDefaultN(3))
+	ctx := context.Background()
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 3 || res.Remaining != 7 {
+		t.Fatalf("got %+v, %v, want Allow to consume the default of 3", res, err)
+	}
+	res, err = l.AllowN(ctx, "k", 1)
+	if err != nil || res.Allowed != 1 || res.Remaining != 6 {
+		t.Fatalf("got %+v, %v, want AllowN to consume 1", res, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("WithDefaultN(0) didn't panic")
+		}
+	}()
+	WithDefaultN(0)
+}
+
+func TestPreviousRemaining(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(6)))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	for i, step := range []struct {
+		after    time.Duration
+		n        int
+		previous int
+		allowed  int
+	}{
+		{0, 2, 6, 2},
+		{0, 1, 4, 1},
+		// two events refill in 20s
+		{20 * time.Second, 1, 5, 1},
+		{0, 5, 4, 0},
+	} {
+		now = now.Add(step.after)
+		mr.SetTime(now)
+		res, err := l.AllowN(ctx, "k", step.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.PreviousRemaining != step.previous || res.Allowed != step.allowed {
+			t.Fatalf("call %d: got %d previous and %d allowed, want %d and %d", i, res.PreviousRemaining, res.Allowed, step.previous, step.allowed)
+		}
+		if res.Allowed > 0 && res.PreviousRemaining-res.Remaining != res.Allowed {
+			t.Fatalf("call %d: %d previous - %d remaining != %d allowed", i, res.PreviousRemaining, res.Remaining, res.Allowed)
+		}
+	}
+}
+
+func TestZeroBurst(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	l.SetLimit("blocked", Limit{Rate: 5, Period: time.Minute})
+	ctx := context.Background()
+	before := mr.CommandCount()
+	res, err := l.AllowN(ctx, "blocked", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed != 0 || res.Remaining != 0 || res.RetryAfter != -1 || res.Reason != ReasonBlocked {
+		t.Fatalf("got %+v, want a blocked denial", res)
+	}
+	if n := mr.CommandCount(); n != before {
+		t.Fatalf("a zero burst sent %d commands to Redis", n-before)
+	}
+	if res, err := l.AllowN(ctx, "open", 1); err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want a positive burst to allow", res, err)
+	}
+}
+
+func TestResultPool(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(3)), WithResultPool())
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		res, err := l.AllowN(ctx, "k", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed, remaining := 1, 2-i
+		if i == 3 {
+			allowed, remaining = 0, 0
+		}
+		if res.Allowed != allowed || res.Remaining != remaining || res.FirstSeen != (i == 0) || res.Limit != PerMinute(3) {
+			t.Fatalf("call %d: got %+v from the pool", i, res)
+		}
+		res.Release()
+	}
+}
+
+func TestSentinel(t *testing.T) {
+	for _, tc := range []struct {
+		sentinel Sentinel
+		want     time.Duration
+	}{
+		{SentinelNegativeOne, -1},
+		{SentinelZero, 0},
+	} {
+		l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)), WithSentinel(tc.sentinel))
+		ctx := context.Background()
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RetryAfter != tc.want || res.ResetAfter <= 0 {
+			t.Errorf("sentinel %d: got retry after %v and reset after %v", tc.sentinel, res.RetryAfter, res.ResetAfter)
+		}
+		l.SetEnabled(false)
+		if res, _ := l.Allow(ctx, "k"); res.RetryAfter != tc.want {
+			t.Errorf("sentinel %d: got retry after %v while disabled", tc.sentinel, res.RetryAfter)
+		}
+	}
+}
+
+func TestSubMillisecondInterval(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(Limit{Rate: 1000000, Burst: 10, Period: time.Second}))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	ctx := context.Background()
+	if res, err := l.AllowN(ctx, "k", 10); err != nil || res.Allowed != 10 {
+		t.Fatalf("got %+v, %v, want the burst allowed", res, err)
+	}
+	if res, err := l.AllowN(ctx, "k", 1); err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want a denial once the burst is used", res, err)
+	}
+	// an event refills every microsecond
+	mr.SetTime(now.Add(5 * time.Microsecond))
+	if res, err := l.AllowN(ctx, "k", 5); err != nil || res.Allowed != 5 {
+		t.Fatalf("got %+v, %v, want the 5 refilled events allowed", res, err)
+	}
+	if res, err := l.AllowN(ctx, "k", 1); err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want a denial after the refilled events", res, err)
+	}
+}
+
+func TestPeekMany(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	l.SetLimit("custom", PerMinute(2))
+	l.SetPolicy("window", KeyPolicy{Limit: PerMinute(4), Algorithm: AlgoFixedWindow})
+	ctx := context.Background()
+	for key, n := range map[string]int{"used": 3, "custom": 1, "window": 2} {
+		if _, err := l.AllowN(ctx, key, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{"window", "fresh", "used", "custom"}
+	results, err := l.PeekMany(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		limit     Limit
+		remaining int
+	}{
+		{PerMinute(4), 2},
+		{PerMinute(5), 5},
+		{PerMinute(5), 2},
+		{PerMinute(2), 1},
+	} {
+		if res := results[i]; res.Limit != want.limit || res.Remaining != want.remaining || res.Allowed != 0 {
+			t.Errorf("key %q: got limit %v and %d remaining, want %v and %d", keys[i], res.Limit, res.Remaining, want.limit, want.remaining)
+		}
+	}
+}
+
+func TestRefillBurst(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerHour(5)))
+	ctx := context.Background()
+	if _, err := l.AllowN(ctx, "k", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RefillBurst(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.Peek(ctx, "k")
+	if err != nil || res.Remaining != 5 {
+		t.Fatalf("got %+v, %v, want the full burst", res, err)
+	}
+	if !mr.Exists(l.redisKey("k")) || mr.TTL(l.redisKey("k")) <= 0 {
+		t.Fatal("RefillBurst didn't keep the key and its expiry")
+	}
+	if err := l.Reset(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(l.redisKey("k")) {
+		t.Fatal("Reset kept the key")
+	}
+	if err := l.RefillBurst(ctx, "missing"); err != nil || mr.Exists(l.redisKey("missing")) {
+		t.Fatalf("got %v, want a missing key to be left alone", err)
+	}
+}
+
+func TestTransferQuota(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerHour(10)))
+	ctx := context.Background()
+	if _, err := l.AllowN(ctx, "{a}old", 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.AllowN(ctx, "{a}new", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TransferQuota(ctx, "{a}old", "{a}new"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(l.redisKey("{a}old")) {
+		t.Fatal("the source key wasn't deleted")
+	}
+	if res, err := l.Peek(ctx, "{a}new"); err != nil || res.Remaining != 2 {
+		t.Fatalf("got %+v, %v, want the more restrictive source usage", res, err)
+	}
+
+	// a less used source leaves the destination as it is
+	if _, err := l.AllowN(ctx, "{a}light", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.TransferQuota(ctx, "{a}light", "{a}new"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := l.Peek(ctx, "{a}new"); res.Remaining != 2 {
+		t.Fatalf("got %d remaining, want the destination's 2", res.Remaining)
+	}
+}
+
+func TestAllowedBefore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &Result{Limit: PerMinute(6), Remaining: 2, ResetAfter: 40 * time.Second}
+	for _, tc := range []struct {
+		in   time.Duration
+		want int
+	}{
+		{-time.Second, 0},
+		{0, 2},
+		// shorter than ResetAfter: the remaining events and two refills
+		{25 * time.Second, 4},
+		// longer than ResetAfter: events keep refilling as they are used
+		{2 * time.Minute, 14},
+	} {
+		if got := res.AllowedBefore(now.Add(tc.in), now); got != tc.want {
+			t.Errorf("deadline in %v: got %d, want %d", tc.in, got, tc.want)
+		}
+	}
+	if got := (&Result{Limit: Limit{Rate: 6, Period: time.Minute}}).AllowedBefore(now.Add(time.Hour), now); got != 0 {
+		t.Errorf("got %d for a zero burst, want 0", got)
+	}
+}
+
+func TestRampReset(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(Limit{Rate: 100, Burst: 100, Period: time.Hour}))
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(start)
+	if _, err := l.AllowN(ctx, "k", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RampReset(ctx, "k", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		at        time.Duration
+		remaining int
+	}{
+		{0, 0},
+		{150 * time.Second, 25},
+		{5 * time.Minute, 50},
+		{450 * time.Second, 75},
+		{10 * time.Minute, 100},
+	} {
+		mr.SetTime(start.Add(tc.at))
+		res, err := l.Peek(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Remaining < tc.remaining-1 || res.Remaining > tc.remaining {
+			t.Fatalf("after %v: %d remaining, want %d", tc.at, res.Remaining, tc.remaining)
+		}
+	}
+
+	// events allowed during the ramp are taken from it
+	mr.SetTime(start)
+	if _, err := l.AllowN(ctx, "k", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RampReset(ctx, "k", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetTime(start.Add(5 * time.Minute))
+	res, err := l.AllowN(ctx, "k", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed != 20 || res.Remaining < 29 || res.Remaining > 30 {
+		t.Fatalf("got %d allowed and %d remaining halfway through the ramp, want 20 and 30", res.Allowed, res.Remaining)
+	}
+	if res, _ := l.Peek(ctx, "k"); res.Remaining > 30 {
+		t.Fatalf("%d remaining right after the call, want at most 30", res.Remaining)
+	}
+}
+
+func TestRampResetRefillsSooner(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(100)))
+	ctx := context.Background()
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if _, err := l.AllowN(ctx, "k", 100); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := mr.Get(l.redisKey("k"))
+	if err := l.RampReset(ctx, "k", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := mr.Get(l.redisKey("k")); after != before {
+		t.Fatalf("stored %q, want %q for a key that refills within the ramp", after, before)
+	}
+}
+
+func TestArgsCacheBounded(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	ctx := context.Background()
+	for i := 1; i <= maxCachedLimits+10; i++ {
+		if _, err := l.AllowAtMost(ctx, "k", PerMinute(i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := l.argsCache.size.Load(); n != maxCachedLimits {
+		t.Fatalf("cached %d limits, want %d", n, maxCachedLimits)
+	}
+}
+
+func TestScriptResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  []float64
+	}{
+		{
+			name:  "all fields",
+			reply: `{"status", 0, "allowed", 1, "remaining", 4, "retry_after", "-1", "reset_after", "0.5", "previous", 5, "window_start", "100.25", "level", 0, "first_seen", 1}`,
+			want:  []float64{0, 1, 4, -1, 0.5, 5, 100.25, 0, 1},
+		},
+		{
+			name:  "missing fields",
+			reply: `{"status", 1, "allowed", 0}`,
+			want:  []float64{1, 0, 0, -1, -1, 0, 0, 0, 0},
+		},
+		{
+			name:  "previous defaults to remaining",
+			reply: `{"status", 0, "allowed", 0, "remaining", 3}`,
+			want:  []float64{0, 0, 3, -1, -1, 3, 0, 0, 0},
+		},
+		{
+			name:  "unknown field",
+			reply: `{"status", 0, "allowed", 1, "bogus", 7, "remaining", 2, "retry_after", "-1"}`,
+			want:  []float64{0, 1, 2, -1, -1, 2, 0, 0, 0},
+		},
+		{
+			name:  "tiers",
+			reply: `{"status", 0, "allowed", 1, "remaining", 2, "level", 2, "tiers", {3, "1.5", 2, "2"}}`,
+			want:  []float64{0, 1, 2, -1, -1, 2, 0, 2, 0, 3, 1.5, 2, 2},
+		},
+	}
+	l, _ := newTestLimiter(t)
+	ctx := context.Background()
+	for _, tt := range tests {
+		for _, positional := range []bool{false, true} {
+			src := "return " + tt.reply
+			if positional {
+				src = "local reply = (function()\n" + src + "\nend)()\n" + positionalReply
+			}
+			got, err := scriptResult(l.rdb.Do(ctx, l.rdb.B().Eval().Script(src).Numkeys(0).Build()))
+			if err != nil {
+				t.Errorf("%s, positional %v: %v", tt.name, positional, err)
+				continue
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s, positional %v: got %v, want %v", tt.name, positional, got, tt.want)
+			}
+		}
+	}
+
+	for _, reply := range []string{`{"status", 0, "allowed"}`, `{"status", 0, "allowed", "x"}`} {
+		if _, err := scriptResult(l.rdb.Do(ctx, l.rdb.B().Eval().Script("return "+reply).Numkeys(0).Build())); err == nil {
+			t.Errorf("reply %s decoded without an error", reply)
+		}
+	}
+}
+
+func TestScriptStatus(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		reply   string
+		allowed int
+		reason  Reason
+		err     error
+	}{
+		{`{"status", 0, "allowed", 2, "remaining", 3}`, 2, ReasonNone, nil},
+		{`{"status", 1, "allowed", 0, "retry_after", "1.5"}`, 0, ReasonLimitExceeded, nil},
+		{`{"status", 2}`, 0, ReasonNone, ErrInvalidRequest},
+		// the older format without a status
+		{`{2, 3, "-1", "0.5"}`, 2, ReasonNone, nil},
+	} {
+		raw, err := scriptResult(l.rdb.Do(ctx, l.rdb.B().Eval().Script("return "+tc.reply).Numkeys(0).Build()))
+		if err != nil {
+			t.Fatalf("reply %s: %v", tc.reply, err)
+		}
+		res, err := decodeResult(PerMinute(5), raw)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("reply %s: got error %v, want %v", tc.reply, err, tc.err)
+			continue
+		}
+		if err == nil && (res.Allowed != tc.allowed || res.Reason != tc.reason) {
+			t.Errorf("reply %s: got %d allowed and reason %v, want %d and %v", tc.reply, res.Allowed, res.Reason, tc.allowed, tc.reason)
+		}
+	}
+	if _, err := decodeResult(PerMinute(5), []float64{7, 0, 0, -1, -1, 0}); err == nil {
+		t.Error("an unknown status decoded without an error")
+	}
+}
+
+func FuzzDecodeResult(f *testing.F) {
+	f.Add(int32(0), int32(1), int32(4), -1.0, 0.5, int32(5), 100.25, int32(0), int32(1), uint8(9))
+	f.Add(int32(1), int32(0), int32(-3), 2.0, 10.0, int32(0), 0.0, int32(2), int32(0), uint8(9))
+	f.Add(int32(0), int32(1), int32(4), -1.0, 0.5, int32(5), 0.0, int32(0), int32(0), uint8(5))
+	f.Add(int32(7), int32(1), int32(4), -1.0, 0.5, int32(5), 0.0, int32(0), int32(0), uint8(4))
+	l, _ := newTestLimiter(f)
+	limit := PerMinute(10)
+	f.Fuzz(func(t *testing.T, status, allowed, remaining int32, retry, reset float64, previous int32, windowStart float64, level, firstSeen int32, n uint8) {
+		raw := []float64{float64(status), float64(allowed), float64(remaining), retry, reset,
+			float64(previous), windowStart, float64(level), float64(firstSeen)}
+		raw = raw[:int(n)%(len(raw)+1)]
+		res, err := decodeResult(limit, raw)
+		if err == nil && (res.Remaining < 0 || res.Debt < 0 || res.Used < 0) {
+			t.Fatalf("decodeResult(%v) = %+v", raw, res)
+		}
+		if len(raw) != len(resultFields) {
+			return
+		}
+
+		// the same values as named fields, and through the positional
+		// wrapper, decode the same way
+		named := "return {"
+		for i, name := range resultFields {
+			v := strconv.FormatFloat(raw[i], 'g', -1, 64)
+			if i == 3 || i == 4 || i == 6 {
+				v = `"` + v + `"`
+			}
+			named += `"` + name + `", ` + v + ", "
+		}
+		named += "}"
+		for _, src := range []string{named, "local reply = (function()\n" + named + "\nend)()\n" + positionalReply} {
+			got, err := scriptResult(l.rdb.Do(context.Background(), l.rdb.B().Eval().Script(src).Numkeys(0).Build()))
+			if err != nil {
+				t.Fatalf("%s: %v", src, err)
+			}
+			if !slices.EqualFunc(got, raw, func(a, b float64) bool { return a == b || math.IsNaN(a) && math.IsNaN(b) }) {
+				t.Fatalf("%s: got %v, want %v", src, got, raw)
+			}
+		}
+	})
+}
+
+func TestDebugRawResult(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(2)))
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Raw != nil {
+		t.Fatalf("got %+v, %v, want no raw values without the option", res, err)
+	}
+
+	l, _ = newTestLimiter(t, WithRateLimit(PerMinute(2)), WithDebugRawResult())
+	res, err = l.Allow(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Raw) != len(resultFields) || res.Raw[0] != statusOK || res.Raw[1] != 1 || res.Raw[2] != 1 {
+		t.Fatalf("got raw values %v, want an allowed event with 1 remaining", res.Raw)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)))
+	ctx := context.Background()
+	for i, want := range []bool{true, false} {
+		allowed, res, err := l.Check(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != want || allowed != (res.Allowed > 0) {
+			t.Fatalf("call %d: got %v with %d allowed, want %v", i, allowed, res.Allowed, want)
+		}
+	}
+}
+
+func TestMaxN(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)), WithMaxN(5))
+	ctx := context.Background()
+	for _, n := range []int{4, 5} {
+		if res, err := l.AllowN(ctx, "k", n); err != nil || res.Allowed != n {
+			t.Fatalf("n %d: got %+v, %v, want it allowed", n, res, err)
+		}
+	}
+	before := mr.CommandCount()
+	if _, err := l.AllowN(ctx, "other", 6); !errors.Is(err, ErrNExceedsMax) {
+		t.Fatalf("got %v, want ErrNExceedsMax", err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("oversized call sent %d commands", n)
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	res, err := l.AllowN(context.Background(), "k", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ResetAfter != 24*time.Second {
+		t.Fatalf("got reset after %v, want 24s", res.ResetAfter)
+	}
+	end := res.WindowStart.Add(res.Limit.Period)
+	if d := end.Sub(now.Add(res.ResetAfter)); d < -time.Millisecond || d > time.Millisecond {
+		t.Fatalf("window ends at %v, want %v", end, now.Add(res.ResetAfter))
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(2)), WithEnabled(false))
+	ctx := context.Background()
+	allow := func(enabled bool, want int) {
+		t.Helper()
+		before := mr.CommandCount()
+		res, err := l.Allow(ctx, "k")
+		if err != nil || res.Allowed != want {
+			t.Fatalf("got %+v, %v, want %d allowed", res, err, want)
+		}
+		if sent := mr.CommandCount() > before; sent != enabled {
+			t.Fatalf("commands sent: %v, want %v", sent, enabled)
+		}
+	}
+	allow(false, 1)
+	l.SetEnabled(true)
+	allow(true, 1)
+	allow(true, 1)
+	allow(true, 0)
+	l.SetEnabled(false)
+	allow(false, 1)
+	allow(false, 1)
+	l.SetEnabled(true)
+	allow(true, 0)
+
+	// toggling concurrently with calls is safe
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				l.SetEnabled(j%2 == 0)
+				if _, err := l.Allow(ctx, "k"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMinRetryAfter(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithMinRetryAfter(2*time.Minute))
+	ctx := context.Background()
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.RetryAfter != -1 {
+		t.Fatalf("got %+v, %v, want an allowed result's RetryAfter untouched", res, err)
+	}
+	res, err = l.Allow(ctx, "k")
+	if err != nil || res.RetryAfter != 2*time.Minute {
+		t.Fatalf("got %+v, %v, want RetryAfter raised to the floor", res, err)
+	}
+
+	l, _ = newTestLimiter(t, WithRateLimit(PerMinute(1)), WithMinRetryAfter(time.Second))
+	l.Allow(ctx, "k")
+	res, err = l.Allow(ctx, "k")
+	if err != nil || res.RetryAfter < 59*time.Second {
+		t.Fatalf("got %+v, %v, want RetryAfter above the floor unchanged", res, err)
+	}
+}
+
+func TestResultLimit(t *testing.T) {
+	limits := haxmap.New[string, Limit]()
+	limits.Set("custom", PerSecond(5))
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)), WithCustomLimits(limits), WithFailureMode(FailOpen))
+	ctx := context.Background()
+	check := func(key string, want Limit) {
+		t.Helper()
+		res, err := l.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Limit != want || res.LimitString() != want.String() {
+			t.Fatalf("%s: got limit %v (%s), want %v", key, res.Limit, res.LimitString(), want)
+		}
+	}
+	check("default", PerMinute(10))
+	check("custom", PerSecond(5))
+	mr.SetError("ERR unavailable")
+	check("custom", PerSecond(5))
+}
+
+func TestRejected(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(3)))
+	ctx := context.Background()
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Rejected != 0 {
+		t.Fatalf("got %+v, %v, want nothing rejected", res, err)
+	}
+	res, err = l.AllowAtMost(ctx, "k", PerMinute(3), 5)
+	if err != nil || res.Allowed != 2 || res.Rejected != 3 {
+		t.Fatalf("got %+v, %v, want 2 allowed and 3 rejected", res, err)
+	}
+	res, err = l.AllowN(ctx, "k", 2)
+	if err != nil || res.Allowed != 0 || res.Rejected != 2 {
+		t.Fatalf("got %+v, %v, want the denied events rejected", res, err)
+	}
+}
+
+func TestAllowIfRemaining(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	// 10 remaining: consuming 3 would leave 7, below the minimum of 8
+	res, err := l.AllowIfRemaining(ctx, "k", 3, 8)
+	if err != nil || res.Allowed != 0 || res.Remaining != 10 {
+		t.Fatalf("got %+v, %v, want a denial with nothing consumed", res, err)
+	}
+	res, err = l.AllowIfRemaining(ctx, "k", 3, 7)
+	if err != nil || res.Allowed != 3 || res.Remaining != 7 {
+		t.Fatalf("got %+v, %v, want the events allowed at the minimum", res, err)
+	}
+	res, err = l.AllowIfRemaining(ctx, "k", 1, 6)
+	if err != nil || res.Allowed != 1 || res.Remaining != 6 {
+		t.Fatalf("got %+v, %v, want the event allowed above the minimum", res, err)
+	}
+	res, err = l.AllowIfRemaining(ctx, "k", 1, 6)
+	if err != nil || res.Allowed != 0 || res.Remaining != 6 {
+		t.Fatalf("got %+v, %v, want a denial below the minimum", res, err)
+	}
+}
+
+func TestSoftLimit(t *testing.T) {
+	limit := PerMinute(4)
+	limit.SoftLimit = 2
+	l, mr := newTestLimiter(t, WithRateLimit(limit))
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	for i, over := range []bool{false, false, true, true} {
+		res, err := l.Allow(ctx, "k")
+		if err != nil || res.Allowed != 1 || res.OverSoftLimit != over {
+			t.Fatalf("call %d: got %+v, %v, want it allowed, over the soft limit: %v", i, res, err, over)
+		}
+	}
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want a denial beyond the hard limit", res, err)
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	for _, algo := range []Algorithm{AlgoGCRA, AlgoFixedWindow} {
+		l, _ := newTestLimiter(t, WithAlgorithm(algo), WithRateLimit(PerMinute(5)))
+		ctx := context.Background()
+		check := func(want bool) {
+			t.Helper()
+			res, err := l.Allow(ctx, "k")
+			if err != nil || res.FirstSeen != want {
+				t.Fatalf("%v: got %+v, %v, want FirstSeen %v", algo, res, err, want)
+			}
+		}
+		check(true)
+		check(false)
+		if err := l.Reset(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+		check(true)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	l, mr := newTestLimiter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := mr.CommandCount()
+	if _, err := l.AllowN(ctx, "k", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from AllowN, want context.Canceled", err)
+	}
+	if _, err := l.AllowAtMost(ctx, "k", PerMinute(1), 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from AllowAtMost, want context.Canceled", err)
+	}
+	if err := l.Reset(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v from Reset, want context.Canceled", err)
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("cancelled calls sent %d commands", n)
+	}
+}
+
+func TestLazyTTL(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(60)), WithLazyTTL(time.Minute))
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	key := l.redisKey("k")
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	first := mr.TTL(key)
+	for i := 0; i < 9; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ttl := mr.TTL(key); ttl != first {
+		t.Fatalf("got TTL %v, want %v kept while it outlives the state", ttl, first)
+	}
+
+	// an expiry short of the state's is extended by the refresh interval
+	mr.SetTTL(key, 5*time.Second)
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(key); ttl != first+10*time.Second {
+		t.Fatalf("got TTL %v after the refresh, want %v", ttl, first+10*time.Second)
+	}
+}
+
+func TestPerSecondRate(t *testing.T) {
+	for _, limit := range []Limit{PerSecond(1), PerMinute(60), PerHour(3600), PerDay(86400)} {
+		if r := limit.PerSecondRate(); r != 1 {
+			t.Errorf("%v: got %v per second, want 1", limit, r)
+		}
+	}
+	if r := (Limit{Rate: 5}).PerSecondRate(); r != 0 {
+		t.Errorf("got %v per second for a zero period, want 0", r)
+	}
+}
+
+func TestBypass(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(1)), WithBypass(func(key string) bool {
+		return key == "internal"
+	}))
+	ctx := context.Background()
+	before := mr.CommandCount()
+	for i := 0; i < 3; i++ {
+		if res, err := l.Allow(ctx, "internal"); err != nil || res.Allowed != 1 {
+			t.Fatalf("got %+v, %v, want the bypassed key allowed", res, err)
+		}
+	}
+	if n := mr.CommandCount() - before; n != 0 {
+		t.Fatalf("bypassed key sent %d commands", n)
+	}
+	for i, want := range []int{1, 0} {
+		if res, err := l.Allow(ctx, "user"); err != nil || res.Allowed != want {
+			t.Fatalf("call %d: got %+v, %v, want %d allowed", i, res, err, want)
+		}
+	}
+}
+
+func TestResetIfIdle(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerHour(10)))
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	if _, err := l.AllowN(ctx, "k", 4); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.SetTime(now.Add(30 * time.Second))
+	if reset, err := l.ResetIfIdle(ctx, "k", time.Minute); err != nil || reset {
+		t.Fatalf("got %v, %v, want an active key kept", reset, err)
+	}
+	if res, err := l.Peek(ctx, "k"); err != nil || res.Remaining != 6 {
+		t.Fatalf("got %+v, %v, want the active key's state", res, err)
+	}
+
+	mr.SetTime(now.Add(2 * time.Minute))
+	if reset, err := l.ResetIfIdle(ctx, "k", time.Minute); err != nil || !reset {
+		t.Fatalf("got %v, %v, want an idle key reset", reset, err)
+	}
+	if res, err := l.Peek(ctx, "k"); err != nil || res.Remaining != 10 {
+		t.Fatalf("got %+v, %v, want the full capacity after the reset", res, err)
+	}
+	if reset, err := l.ResetIfIdle(ctx, "missing", time.Minute); err != nil || reset {
+		t.Fatalf("got %v, %v for a missing key, want nothing reset", reset, err)
+	}
+}
+
+func TestMaxAllowed(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(5)))
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	for _, tc := range []struct{ consume, want int }{{0, 5}, {2, 3}, {3, 0}} {
+		if _, err := l.AllowN(ctx, "k", tc.consume); err != nil {
+			t.Fatal(err)
+		}
+		n, err := l.MaxAllowed(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := l.Peek(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != tc.want || n != res.Remaining {
+			t.Fatalf("got %d allowed after consuming %d, want %d, the remaining of Peek being %d", n, tc.consume, tc.want, res.Remaining)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	l, mr := newTestLimiter(t)
+	ctx := context.Background()
+	if err := l.Ping(ctx); err != nil {
+		t.Fatalf("got %v from a healthy server", err)
+	}
+	mr.SetError("ERR unavailable")
+	err := l.Ping(ctx)
+	if _, ok := rueidis.IsRedisErr(err); !ok {
+		t.Fatalf("got %v, want the server's error", err)
+	}
+}
+
+func TestExposeTAT(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)))
+	mr.SetTime(now)
+	if res, err := l.Allow(ctx, "k"); err != nil || !res.TAT.IsZero() {
+		t.Fatalf("got %+v, %v, want no TAT without the option", res, err)
+	}
+
+	l, mr = newTestLimiter(t, WithRateLimit(PerMinute(10)), WithExposeTAT())
+	mr.SetTime(now)
+	// the emission interval of 10 events per minute is 6s
+	for i := 1; i <= 3; i++ {
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := now.Add(time.Duration(i) * 6 * time.Second)
+		if d := res.TAT.Sub(want); d < -time.Millisecond || d > time.Millisecond {
+			t.Fatalf("call %d: got TAT %v, want %v", i, res.TAT, want)
+		}
+	}
+}
+
+func TestToXRate(t *testing.T) {
+	for _, tc := range []struct {
+		limit Limit
+		rate  rate.Limit
+		burst int
+	}{
+		{PerSecond(10), 10, 10},
+		{PerMinute(60), 1, 60},
+	} {
+		r, burst := tc.limit.ToXRate()
+		if r != tc.rate || burst != tc.burst {
+			t.Errorf("%v: got %v, %d, want %v, %d", tc.limit, r, burst, tc.rate, tc.burst)
+		}
+	}
+}
+
+func TestIsLimited(t *testing.T) {
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(2)))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	ctx := context.Background()
+	check := func(want bool) {
+		t.Helper()
+		if limited, err := l.IsLimited(ctx, "k"); err != nil || limited != want {
+			t.Fatalf("got %v, %v, want %v", limited, err, want)
+		}
+	}
+	check(false)
+	if _, err := l.AllowN(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	check(true)
+	check(true)
+	// an event refills every 30s
+	mr.SetTime(now.Add(30 * time.Second))
+	check(false)
+}
+
+func TestErrorOnDeny(t *testing.T) {
+	ctx := context.Background()
+	for _, errorOnDeny := range []bool{false, true} {
+		opts := []LimiterOption{WithRateLimit(PerMinute(1))}
+		if errorOnDeny {
+			opts = append(opts, WithErrorOnDeny())
+		}
+		l, _ := newTestLimiter(t, opts...)
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != 1 {
+			t.Fatalf("error on deny %v: got %+v, %v, want the event allowed", errorOnDeny, res, err)
+		}
+		res, err := l.Allow(ctx, "k")
+		if errorOnDeny && !errors.Is(err, ErrRateLimited) || !errorOnDeny && err != nil {
+			t.Fatalf("error on deny %v: got %v", errorOnDeny, err)
+		}
+		if res == nil || res.Allowed != 0 || res.RetryAfter <= 0 {
+			t.Fatalf("error on deny %v: got %+v, want the denied result", errorOnDeny, res)
+		}
+	}
+}
+
+func TestAllowAtMostZero(t *testing.T) {
+	l, mr := newTestLimiter(t)
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	if _, err := l.AllowAtMost(ctx, "k", PerMinute(5), 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := l.AllowAtMost(ctx, "k", PerMinute(5), 0)
+		if err != nil || res.Allowed != 0 || res.Remaining != 3 || res.Reason != ReasonNone {
+			t.Fatalf("call %d: got %+v, %v, want the 3 remaining events without a denial", i, res, err)
+		}
+	}
+	res, err := l.AllowAtMost(ctx, "k", PerMinute(5), 3)
+	if err != nil || res.Allowed != 3 {
+		t.Fatalf("got %+v, %v, want nothing consumed by the inspections", res, err)
+	}
+}
@@ -0,0 +1,122 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrEmptyRule is returned by AllowRule for an And or Or without rules.
+var ErrEmptyRule = errors.New("rate_limiter: rule has no sub-rules")
+
+// Rule combines the limits of several keys. Rules are built with Key, And
+// and Or and evaluated with Limiter.AllowRule.
+type Rule interface {
+	eval(ctx context.Context, l Limiter, n int, consumed *[]string) (*Result, error)
+}
+
+type keyRule string
+
+type andRule []Rule
+
+type orRule []Rule
+
+// Key returns a Rule that passes when n events are allowed for key.
+func Key(key string) Rule {
+	return keyRule(key)
+}
+
+// And returns a Rule that passes when all of rules pass.
+func And(rules ...Rule) Rule {
+	return andRule(rules)
+}
+
+// Or returns a Rule that passes when any of rules passes. Rules are tried in
+// order and only the first passing rule consumes events.
+func Or(rules ...Rule) Rule {
+	return orRule(rules)
+}
+
+// AllowRule reports whether n events may happen at time now according to
+// rule. Events are only consumed when the rule passes: keys that were
+// consumed on the way to a denial are refunded. The rule is not evaluated
+// atomically, so concurrent callers may briefly observe consumption that is
+// later refunded. AllowHierarchy checks every key before consuming any, in a
+// single script, for rules that are an And of keys.
+func (l Limiter) AllowRule(ctx context.Context, rule Rule, n int) (*Result, error) {
+	var consumed []string
+	res, err := rule.eval(ctx, l, n, &consumed)
+	if err != nil || res.Allowed == 0 {
+		if rerr := l.refundKeys(ctx, consumed, n); err == nil {
+			err = rerr
+		}
+	}
+	return res, err
+}
+
+func (l Limiter) refundKeys(ctx context.Context, keys []string, n int) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := l.Refund(ctx, key, n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (r keyRule) eval(ctx context.Context, l Limiter, n int, consumed *[]string) (*Result, error) {
+	res, err := l.allowN(ctx, string(r), n)
+	if err != nil {
+		return nil, err
+	}
+	if res.Allowed > 0 {
+		*consumed = append(*consumed, string(r))
+	}
+	return res, nil
+}
+
+// eval passes with the result that has the least remaining, or returns the
+// first denial after refunding the rules that passed before it.
+func (r andRule) eval(ctx context.Context, l Limiter, n int, consumed *[]string) (*Result, error) {
+	if len(r) == 0 {
+		return nil, ErrEmptyRule
+	}
+	var least *Result
+	for _, rule := range r {
+		res, err := rule.eval(ctx, l, n, consumed)
+		if err != nil || res.Allowed == 0 {
+			return res, err
+		}
+		if least == nil || res.Remaining < least.Remaining {
+			least = res
+		}
+	}
+	return least, nil
+}
+
+// eval passes with the first passing rule, or returns the denial that can be
+// retried soonest.
+func (r orRule) eval(ctx context.Context, l Limiter, n int, consumed *[]string) (*Result, error) {
+	if len(r) == 0 {
+		return nil, ErrEmptyRule
+	}
+	var soonest *Result
+	for _, rule := range r {
+		mark := len(*consumed)
+		res, err := rule.eval(ctx, l, n, consumed)
+		if err != nil {
+			return nil, err
+		}
+		if res.Allowed > 0 {
+			return res, nil
+		}
+		// a nested And may have consumed some keys before denying
+		if err := l.refundKeys(ctx, (*consumed)[mark:], n); err != nil {
+			return nil, err
+		}
+		*consumed = (*consumed)[:mark]
+		if soonest == nil || res.RetryAfter < soonest.RetryAfter {
+			soonest = res
+		}
+	}
+	return soonest, nil
+}
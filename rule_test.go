@@ -0,0 +1,85 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alphadose/haxmap"
+)
+
+func ruleLimiter(t *testing.T) (*Limiter, context.Context) {
+	limits := haxmap.New[string, Limit]()
+	limits.Set("user", PerMinute(1))
+	limits.Set("admin", PerMinute(1))
+	limits.Set("team", PerMinute(2))
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(5)), WithCustomLimits(limits))
+	return l, context.Background()
+}
+
+func remaining(t *testing.T, l *Limiter, ctx context.Context, key string) int {
+	t.Helper()
+	res, err := l.Peek(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Remaining
+}
+
+func TestAllowRuleAnd(t *testing.T) {
+	l, ctx := ruleLimiter(t)
+	if _, err := l.Allow(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.AllowRule(ctx, And(Key("team"), Key("user")), 1)
+	if err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want a denial", res, err)
+	}
+	if n := remaining(t, l, ctx, "team"); n != 2 {
+		t.Fatalf("team has %d remaining, want the denied And refunded", n)
+	}
+}
+
+func TestAllowRuleOr(t *testing.T) {
+	l, ctx := ruleLimiter(t)
+	if _, err := l.Allow(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.AllowRule(ctx, Or(Key("user"), Key("admin"), Key("team")), 1)
+	if err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the admin override to pass", res, err)
+	}
+	if n := remaining(t, l, ctx, "admin"); n != 0 {
+		t.Fatalf("admin has %d remaining, want 0", n)
+	}
+	if n := remaining(t, l, ctx, "team"); n != 2 {
+		t.Fatalf("team has %d remaining, want it untouched after an earlier rule passed", n)
+	}
+
+	if _, err := l.AllowRule(ctx, Or(), 1); err != ErrEmptyRule {
+		t.Fatalf("got %v, want ErrEmptyRule", err)
+	}
+}
+
+func TestAllowRuleNested(t *testing.T) {
+	l, ctx := ruleLimiter(t)
+	if _, err := l.Allow(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	// the first And consumes team before user denies, and is refunded
+	rule := Or(And(Key("team"), Key("user")), And(Key("team"), Key("admin")))
+	res, err := l.AllowRule(ctx, rule, 1)
+	if err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the second And to pass", res, err)
+	}
+	if n := remaining(t, l, ctx, "team"); n != 1 {
+		t.Fatalf("team has %d remaining, want a single event consumed", n)
+	}
+
+	res, err = l.AllowRule(ctx, rule, 1)
+	if err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want a denial", res, err)
+	}
+	if n := remaining(t, l, ctx, "team"); n != 1 {
+		t.Fatalf("team has %d remaining, want the denied rule refunded", n)
+	}
+}
@@ -0,0 +1,165 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/redis/rueidis"
+)
+
+const scanCount = 1000
+
+// ErrEmptyPattern is returned by ResetPattern for an empty pattern. Use
+// ResetAll to delete every key.
+var ErrEmptyPattern = errors.New("rate_limiter: empty key pattern")
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// scan calls fn with every batch of keys under the limiter's prefix that
+// match pattern, leaving out auxiliary keys.
+func (l Limiter) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	return l.scanMatch(ctx, globEscaper.Replace(l.prefix)+pattern, func(keys []string) error {
+		primary := keys[:0]
+		for _, k := range keys {
+			if !l.isAuxKey(k) {
+				primary = append(primary, k)
+			}
+		}
+		if len(primary) == 0 {
+			return nil
+		}
+		return fn(primary)
+	})
+}
+
+// scanMatch calls fn with every batch of keys matching the glob match.
+// Replica nodes are skipped so that keys are not visited twice. Keys added or
+// removed while scanning may or may not be visited.
+func (l Limiter) scanMatch(ctx context.Context, match string, fn func(keys []string) error) error {
+	for _, node := range l.rdb.Nodes() {
+		replica, err := isReplica(ctx, node)
+		if err != nil {
+			return err
+		}
+		if replica {
+			continue
+		}
+		var cursor uint64
+		for {
+			cmd := node.B().Scan().Cursor(cursor).Match(match).Count(scanCount).Build()
+			entry, err := node.Do(ctx, cmd).AsScanEntry()
+			if err != nil {
+				return err
+			}
+			if len(entry.Elements) > 0 {
+				if err := fn(entry.Elements); err != nil {
+					return err
+				}
+			}
+			if entry.Cursor == 0 {
+				break
+			}
+			cursor = entry.Cursor
+		}
+	}
+	return nil
+}
+
+func isReplica(ctx context.Context, node rueidis.Client) (bool, error) {
+	role, err := node.Do(ctx, node.B().Role().Build()).ToArray()
+	if err != nil {
+		return false, err
+	}
+	if len(role) == 0 {
+		return false, nil
+	}
+	name, err := role[0].ToString()
+	if err != nil {
+		return false, err
+	}
+	return name == "slave", nil
+}
+
+// Count returns the number of keys currently stored under the limiter's
+// prefix. The count is computed with SCAN and is best-effort when keys are
+// created or expire while counting.
+func (l Limiter) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := l.scan(ctx, "*", func(keys []string) error {
+		count += int64(len(keys))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ResetPattern deletes every key under the limiter's prefix that matches the
+// glob pattern, for example "tenant-42:*", and returns the number of keys
+// deleted. The pattern is matched against the keys as stored, so it must be
+// encoded with the limiter's key encoding. Unlike Reset, the state kept next
+// to the keys by options such as WithResetGeneration is left as it is. Keys
+// are deleted with UNLINK as they are found, so an error may leave some of
+// them deleted.
+func (l Limiter) ResetPattern(ctx context.Context, pattern string) (int, error) {
+	if pattern == "" {
+		return 0, ErrEmptyPattern
+	}
+	return l.unlink(ctx, pattern)
+}
+
+// ResetAll deletes every key under the limiter's prefix and returns the
+// number of keys deleted, as ResetPattern does for the pattern "*". The state
+// kept next to the keys, such as their denial counts, is deleted as well but
+// not counted.
+func (l Limiter) ResetAll(ctx context.Context) (int, error) {
+	deleted, err := l.unlink(ctx, "*")
+	if err != nil {
+		return deleted, err
+	}
+	aux := globEscaper.Replace(l.prefix+auxMarker) + "*"
+	err = l.scanMatch(ctx, aux, func(keys []string) error {
+		auxKeys := keys[:0]
+		for _, k := range keys {
+			if l.isAuxKey(k) {
+				auxKeys = append(auxKeys, k)
+			}
+		}
+		_, err := l.unlinkKeys(ctx, auxKeys)
+		return err
+	})
+	return deleted, err
+}
+
+func (l Limiter) unlink(ctx context.Context, pattern string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var deleted int
+	err := l.scan(ctx, pattern, func(keys []string) error {
+		n, err := l.unlinkKeys(ctx, keys)
+		deleted += n
+		return err
+	})
+	return deleted, err
+}
+
+// unlinkKeys deletes keys and returns the number of keys deleted.
+func (l Limiter) unlinkKeys(ctx context.Context, keys []string) (int, error) {
+	// one command per key since the keys may live in other cluster slots
+	cmds := make(rueidis.Commands, 0, len(keys))
+	for _, k := range keys {
+		cmds = append(cmds, l.rdb.B().Unlink().Key(k).Build())
+	}
+	var deleted int
+	for _, resp := range l.rdb.DoMulti(ctx, cmds...) {
+		n, err := resp.AsInt64()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,138 @@
+package rate_limiter
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// WithClock sets the function used to read the current time for decisions
+// made in the process, such as limit schedules and the circuit breaker. The
+// scripts always use the Redis server time.
+func WithClock(now func() time.Time) LimiterOption {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// ScheduledLimit applies Limit during a daily time-of-day window. Start and
+// End are offsets from midnight in the clock's location; a window with End
+// before Start wraps around midnight.
+type ScheduledLimit struct {
+	Start time.Duration
+	End   time.Duration
+	Limit Limit
+}
+
+func (s ScheduledLimit) active(offset time.Duration) bool {
+	if s.Start <= s.End {
+		return offset >= s.Start && offset < s.End
+	}
+	return offset >= s.Start || offset < s.End
+}
+
+// WithLimitSchedule replaces the default limit with the limit of the first
+// schedule entry whose window contains the current time. Keys with a custom
+// limit are not affected.
+func WithLimitSchedule(schedule []ScheduledLimit) LimiterOption {
+	return func(l *Limiter) {
+		l.schedule = schedule
+	}
+}
+
+// WithScheduleFromRedis loads the limit schedule from the Redis key key and
+// reloads it every pollInterval, so that the schedule of every instance can
+// be changed at once. The key holds a JSON encoded []ScheduledLimit. Once
+// loaded it replaces the schedule set by WithLimitSchedule. A missing key
+// clears the schedule, while a value that can't be loaded keeps the previous
+// one. Polling runs in the background until Close is called. It panics if
+// pollInterval is not positive.
+func WithScheduleFromRedis(key string, pollInterval time.Duration) LimiterOption {
+	if pollInterval <= 0 {
+		panic("rate_limiter: schedule poll interval must be positive")
+	}
+	return func(l *Limiter) {
+		l.remoteSchedule = &remoteSchedule{
+			key:      key,
+			interval: pollInterval,
+			stop:     make(chan struct{}),
+		}
+	}
+}
+
+type remoteSchedule struct {
+	key      string
+	interval time.Duration
+	current  atomic.Pointer[[]ScheduledLimit]
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// poll loads the schedule until Close is called.
+func (s *remoteSchedule) poll(rdb rueidis.Client) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.load(rdb)
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *remoteSchedule) load(rdb rueidis.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+	b, err := rdb.Do(ctx, rdb.B().Get().Key(s.key).Build()).AsBytes()
+	if rueidis.IsRedisNil(err) {
+		s.current.Store(&[]ScheduledLimit{})
+		return
+	}
+	if err != nil {
+		return
+	}
+	var schedule []ScheduledLimit
+	if err := json.Unmarshal(b, &schedule); err != nil {
+		return
+	}
+	s.current.Store(&schedule)
+}
+
+// Close stops the background work started by the limiter's options, such as
+// WithScheduleFromRedis and WithShadowLimiter. Clones share that work with
+// the limiter they were cloned from.
+func (l *Limiter) Close() {
+	if l.remoteSchedule != nil {
+		l.remoteSchedule.stopOnce.Do(func() { close(l.remoteSchedule.stop) })
+	}
+	if l.shadow != nil {
+		l.shadow.stopOnce.Do(func() { close(l.shadow.stop) })
+	}
+}
+
+func (l Limiter) scheduledLimit() (Limit, bool) {
+	schedule := l.schedule
+	if l.remoteSchedule != nil {
+		if s := l.remoteSchedule.current.Load(); s != nil {
+			schedule = *s
+		}
+	}
+	if len(schedule) == 0 {
+		return Limit{}, false
+	}
+	now := l.now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	for _, s := range schedule {
+		if s.active(offset) {
+			return s.Limit, true
+		}
+	}
+	return Limit{}, false
+}
@@ -0,0 +1,87 @@
+package rate_limiter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLimitSchedule(t *testing.T) {
+	now := time.Date(2026, 1, 1, 8, 59, 59, 0, time.UTC)
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(10)), WithClock(func() time.Time { return now }),
+		WithLimitSchedule([]ScheduledLimit{
+			{Start: 9 * time.Hour, End: 17 * time.Hour, Limit: PerMinute(2)},
+			{Start: 22 * time.Hour, End: 2 * time.Hour, Limit: PerMinute(5)},
+		}))
+	l.SetLimit("custom", PerMinute(3))
+	ctx := context.Background()
+	for _, tc := range []struct {
+		at    time.Time
+		limit Limit
+	}{
+		{time.Date(2026, 1, 1, 8, 59, 59, 0, time.UTC), PerMinute(10)},
+		{time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC), PerMinute(2)},
+		{time.Date(2026, 1, 1, 16, 59, 59, 0, time.UTC), PerMinute(2)},
+		{time.Date(2026, 1, 1, 17, 0, 0, 0, time.UTC), PerMinute(10)},
+		{time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC), PerMinute(5)},
+		{time.Date(2026, 1, 2, 1, 59, 59, 0, time.UTC), PerMinute(5)},
+		{time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC), PerMinute(10)},
+	} {
+		now = tc.at
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Limit != tc.limit {
+			t.Errorf("at %v: got limit %v, want %v", tc.at.Format(time.TimeOnly), res.Limit, tc.limit)
+		}
+		if res, _ := l.Allow(ctx, "custom"); res.Limit != PerMinute(3) {
+			t.Errorf("at %v: got limit %v for the custom key", tc.at.Format(time.TimeOnly), res.Limit)
+		}
+	}
+}
+
+func TestScheduleFromRedis(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)), WithClock(func() time.Time { return now }),
+		WithScheduleFromRedis("schedule", 10*time.Millisecond))
+	t.Cleanup(l.Close)
+	ctx := context.Background()
+	// waitFor polls until the enforced limit is want
+	waitFor := func(want Limit) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			res, err := l.Peek(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Limit == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got limit %v, want %v", res.Limit, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	set := func(limit Limit) {
+		b, err := json.Marshal([]ScheduledLimit{{Start: 9 * time.Hour, End: 17 * time.Hour, Limit: limit}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr.Set("schedule", string(b))
+	}
+	waitFor(PerMinute(10))
+	set(PerMinute(2))
+	waitFor(PerMinute(2))
+	set(PerMinute(5))
+	waitFor(PerMinute(5))
+
+	mr.Set("schedule", "not json")
+	time.Sleep(50 * time.Millisecond)
+	waitFor(PerMinute(5))
+	mr.Del("schedule")
+	waitFor(PerMinute(10))
+}
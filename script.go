@@ -0,0 +1,185 @@
+package rate_limiter
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// script is a Lua script run with EVALSHA, falling back to EVAL when Redis
+// doesn't have it cached yet.
+type script struct {
+	src string
+	sha string
+	// positional is the variant of the script returning results in the
+	// positional format, used WithPositionalResults.
+	positional *script
+}
+
+func newScript(src string) *script {
+	s := register(src)
+	s.positional = register("local reply = (function()\n" + src + "\nend)()\n" + positionalReply)
+	return s
+}
+
+func register(src string) *script {
+	sum := sha1.Sum([]byte(src))
+	s := &script{src: src, sha: hex.EncodeToString(sum[:])}
+	registered = append(registered, s)
+	return s
+}
+
+// positionalReply turns the named fields returned by a script into the
+// positional format {status, allowed, remaining, retry_after, reset_after,
+// previous, window_start, level, first_seen} followed by the tiers. Replies
+// that are not results are returned as they are.
+const positionalReply = `if type(reply) ~= "table" or reply[1] ~= "status" then
+  return reply
+end
+local fields = {}
+for i = 1, #reply, 2 do
+  fields[reply[i]] = reply[i + 1]
+end
+local result = {
+  fields.status or 0,
+  fields.allowed or 0,
+  fields.remaining or 0,
+  fields.retry_after or "-1",
+  fields.reset_after or "-1",
+  fields.previous or fields.remaining or 0,
+  fields.window_start or 0,
+  fields.level or 0,
+  fields.first_seen or 0,
+}
+for _, v in ipairs(fields.tiers or {}) do
+  table.insert(result, v)
+end
+return result`
+
+// WithPositionalResults makes the scripts return their results in the older
+// positional format, an array of numbers, instead of named fields, for tools
+// that read the replies of the scripts themselves. Results are decoded the
+// same either way.
+func WithPositionalResults() LimiterOption {
+	return func(l *Limiter) {
+		l.positionalResults = true
+	}
+}
+
+// variant returns the variant of s run by the limiter.
+func (l Limiter) variant(s *script) *script {
+	if l.positionalResults && s.positional != nil {
+		return s.positional
+	}
+	return s
+}
+
+func isNoScript(err error) bool {
+	rerr, ok := rueidis.IsRedisErr(err)
+	return ok && rerr.IsNoScript()
+}
+
+// eval runs s with keys and args.
+func (l Limiter) eval(ctx context.Context, s *script, keys, args []string) rueidis.RedisResult {
+	defer l.stats.observe(time.Now())
+	s = l.variant(s)
+	if l.functions.enabled() {
+		if resp, ok := l.fcall(ctx, s, keys, args); ok {
+			return resp
+		}
+	}
+	resp := l.rdb.Do(ctx, l.rdb.B().Evalsha().Sha1(s.sha).Numkeys(int64(len(keys))).Key(keys...).Arg(args...).Build())
+	if isNoScript(resp.Error()) {
+		l.stats.reload(s.sha)
+		resp = l.rdb.Do(ctx, l.rdb.B().Eval().Script(s.src).Numkeys(int64(len(keys))).Key(keys...).Arg(args...).Build())
+	}
+	if resp.Error() == nil {
+		l.stats.markLoaded(s.sha)
+	}
+	return resp
+}
+
+// evalMulti pipelines s for every exec. Executions that fail with NOSCRIPT
+// are retried with EVAL in a second pipeline.
+func (l Limiter) evalMulti(ctx context.Context, s *script, execs []rueidis.LuaExec) []rueidis.RedisResult {
+	defer l.stats.observe(time.Now())
+	s = l.variant(s)
+	if l.functions.enabled() {
+		if resps, ok := l.fcallMulti(ctx, s, execs); ok {
+			return resps
+		}
+	}
+	cmds := make(rueidis.Commands, len(execs))
+	for i, e := range execs {
+		cmds[i] = l.rdb.B().Evalsha().Sha1(s.sha).Numkeys(int64(len(e.Keys))).Key(e.Keys...).Arg(e.Args...).Build()
+	}
+	resps := l.rdb.DoMulti(ctx, cmds...)
+
+	var retry []int
+	for i, resp := range resps {
+		if isNoScript(resp.Error()) {
+			retry = append(retry, i)
+		} else if resp.Error() == nil {
+			l.stats.markLoaded(s.sha)
+		}
+	}
+	if len(retry) == 0 {
+		return resps
+	}
+	l.stats.reload(s.sha)
+	cmds = cmds[:0]
+	for _, i := range retry {
+		e := execs[i]
+		cmds = append(cmds, l.rdb.B().Eval().Script(s.src).Numkeys(int64(len(e.Keys))).Key(e.Keys...).Arg(e.Args...).Build())
+	}
+	for j, resp := range l.rdb.DoMulti(ctx, cmds...) {
+		resps[retry[j]] = resp
+		if resp.Error() == nil {
+			l.stats.markLoaded(s.sha)
+		}
+	}
+	return resps
+}
+
+// fcallMulti is like evalMulti for function mode. It reports false if
+// functions are not supported.
+func (l Limiter) fcallMulti(ctx context.Context, s *script, execs []rueidis.LuaExec) ([]rueidis.RedisResult, bool) {
+	cmds := make(rueidis.Commands, len(execs))
+	for i, e := range execs {
+		cmds[i] = l.fcallCmd(s, e.Keys, e.Args)
+	}
+	resps := l.rdb.DoMulti(ctx, cmds...)
+	var retry []int
+	for i, resp := range resps {
+		if isUnknownCommand(resp.Error()) {
+			l.functions.unsupported.Store(true)
+			return nil, false
+		}
+		if isFunctionNotFound(resp.Error()) {
+			retry = append(retry, i)
+		} else if resp.Error() == nil {
+			l.stats.markLoaded(libraryName)
+		}
+	}
+	if len(retry) == 0 {
+		return resps, true
+	}
+	ok, err := l.loadLibrary(ctx)
+	if err != nil {
+		return resps, true
+	}
+	if !ok {
+		return nil, false
+	}
+	cmds = cmds[:0]
+	for _, i := range retry {
+		cmds = append(cmds, l.fcallCmd(s, execs[i].Keys, execs[i].Args))
+	}
+	for j, resp := range l.rdb.DoMulti(ctx, cmds...) {
+		resps[retry[j]] = resp
+	}
+	return resps, true
+}
@@ -0,0 +1,88 @@
+package rate_limiter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPositionalResults(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := func(t *testing.T, algo Algorithm, opts ...LimiterOption) []*Result {
+		opts = append(opts, WithAlgorithm(algo), WithRateLimit(Limit{Rate: 3, Burst: 3, Period: time.Minute}))
+		l, mr := newTestLimiter(t, opts...)
+		mr.SetTime(now)
+		ctx := context.Background()
+		var results []*Result
+		for _, n := range []int{2, 5, 1} {
+			res, err := l.AllowN(ctx, "k", n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, res)
+		}
+		res, err := l.Peek(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(results, res)
+	}
+	for _, algo := range append([]Algorithm{AlgoGCRA}, windowAlgorithms...) {
+		named := run(t, algo)
+		positional := run(t, algo, WithPositionalResults())
+		if !reflect.DeepEqual(named, positional) {
+			t.Errorf("algorithm %d: named results %+v, positional %+v", algo, named, positional)
+		}
+		want := []struct{ allowed, remaining, previous int }{{2, 1, 3}, {0, 0, 1}, {1, 0, 1}, {0, 0, 0}}
+		for i, w := range want {
+			got := named[i]
+			if got.Allowed != w.allowed || got.Remaining != w.remaining || got.PreviousRemaining != w.previous {
+				t.Errorf("algorithm %d, call %d: got %+v, want %+v", algo, i, got, w)
+			}
+		}
+	}
+}
+
+func TestPositionalHierarchy(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := func(opts ...LimiterOption) *Result {
+		l, mr := newTestLimiter(t, append(opts, WithRateLimit(PerMinute(5)))...)
+		mr.SetTime(now)
+		l.SetLimit("{t}b", PerMinute(2))
+		res, err := l.AllowHierarchy(context.Background(), []string{"{t}a", "{t}b"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	named, positional := run(), run(WithPositionalResults())
+	if !reflect.DeepEqual(named, positional) {
+		t.Fatalf("named result %+v, positional %+v", named, positional)
+	}
+	if len(named.Tiers) != 2 || named.Tiers[0].Remaining != 4 || named.Tiers[1].Remaining != 1 {
+		t.Fatalf("got tiers %+v", named.Tiers)
+	}
+}
+
+func TestScriptReloads(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.Stats().ScriptReloads; n != 0 {
+		t.Fatalf("got %d reloads after the first load, want 0", n)
+	}
+	if err := l.rdb.Do(ctx, l.rdb.B().ScriptFlush().Build()).Error(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := l.Stats().ScriptReloads; n != 1 {
+		t.Fatalf("got %d reloads after SCRIPT FLUSH, want 1", n)
+	}
+}
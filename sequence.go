@@ -0,0 +1,39 @@
+package rate_limiter
+
+import (
+	"context"
+	"time"
+)
+
+// WithSequence numbers the allowed calls of each key with an increasing
+// counter, reported as Result.Sequence, for example to order decisions
+// logged by different instances. Reset restarts the count, as does the key's
+// state expiring. The counter is stored next to the key and costs an extra
+// round trip per allowed call.
+func WithSequence() LimiterOption {
+	return func(l *Limiter) {
+		l.sequences = true
+	}
+}
+
+func (l Limiter) sequenceKey(key string) string {
+	return l.auxKey("sequence", key)
+}
+
+// withSequence numbers res if it allowed events and WithSequence is enabled.
+// Results that didn't come from Redis, such as those of the failure mode,
+// are not numbered.
+func (l Limiter) withSequence(ctx context.Context, key string, res *Result, err error) (*Result, error) {
+	if !l.sequences || err != nil || res == nil || res.Allowed == 0 || !res.fromRedis {
+		return res, err
+	}
+	sequenceKey := l.sequenceKey(key)
+	ttl := max(res.Limit.Period, time.Second) + l.ttlMarginFor(res.Limit)
+	resps := l.rdb.DoMulti(ctx,
+		l.rdb.B().Incr().Key(sequenceKey).Build(),
+		l.rdb.B().Pexpire().Key(sequenceKey).Milliseconds(ttl.Milliseconds()).Build())
+	if res.Sequence, err = resps[0].AsInt64(); err != nil {
+		return res, l.extraErr(err)
+	}
+	return res, nil
+}
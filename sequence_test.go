@@ -0,0 +1,29 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(PerMinute(2)), WithSequence())
+	ctx := context.Background()
+	for i, want := range []int64{1, 2, 0} {
+		res, err := l.Allow(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Sequence != want {
+			t.Fatalf("call %d: sequence %d, want %d", i, res.Sequence, want)
+		}
+	}
+}
+
+func TestSequenceFailOpen(t *testing.T) {
+	l, mr := newTestLimiter(t, WithSequence(), WithFailureMode(FailOpen))
+	mr.SetError("ERR unavailable")
+	res, err := l.Allow(context.Background(), "k")
+	if err != nil || res == nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want the failure mode's result", res, err)
+	}
+}
@@ -0,0 +1,79 @@
+package rate_limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// shadowQueueSize is how many replays may wait for the shadow limiter.
+	// Calls made while the queue is full are not replayed.
+	shadowQueueSize = 1024
+	// shadowWorkers is how many replays run at once.
+	shadowWorkers = 4
+	// shadowTimeout bounds every replay.
+	shadowTimeout = time.Second
+)
+
+// WithShadowLimiter replays the AllowN calls that reach Redis to shadow in the
+// background, for example to warm up a new Redis deployment before switching
+// to it. The shadow's results and errors are discarded and never affect the
+// limiter's own decisions. Replays are queued and dropped when the shadow
+// falls behind, and each one times out after a second. Close stops them.
+func WithShadowLimiter(shadow *Limiter) LimiterOption {
+	return func(l *Limiter) {
+		l.shadow = &shadowReplayer{
+			limiter: shadow,
+			queue:   make(chan replayedCall, shadowQueueSize),
+			stop:    make(chan struct{}),
+		}
+	}
+}
+
+type replayedCall struct {
+	ctx context.Context
+	key string
+	n   int
+}
+
+type shadowReplayer struct {
+	limiter  *Limiter
+	queue    chan replayedCall
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// start starts the workers replaying calls until Close is called.
+func (s *shadowReplayer) start() {
+	for i := 0; i < shadowWorkers; i++ {
+		go s.run()
+	}
+}
+
+func (s *shadowReplayer) run() {
+	for {
+		select {
+		case c := <-s.queue:
+			ctx, cancel := context.WithTimeout(c.ctx, shadowTimeout)
+			if res, err := s.limiter.allowN(ctx, c.key, c.n); err == nil {
+				res.Release()
+			}
+			cancel()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// replay queues the call to the shadow limiter, if any, keeping the values
+// of ctx but not its cancellation.
+func (l Limiter) replay(ctx context.Context, key string, n int) {
+	if l.shadow == nil {
+		return
+	}
+	select {
+	case l.shadow.queue <- replayedCall{ctx: context.WithoutCancel(ctx), key: key, n: n}:
+	default:
+	}
+}
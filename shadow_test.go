@@ -0,0 +1,57 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShadowReplay(t *testing.T) {
+	shadow, smr := newTestLimiter(t)
+	l, _ := newTestLimiter(t, WithShadowLimiter(shadow), WithMaxN(5))
+	t.Cleanup(l.Close)
+	ctx := context.Background()
+
+	l.SetEnabled(false)
+	if _, err := l.Allow(ctx, "disabled"); err != nil {
+		t.Fatal(err)
+	}
+	l.SetEnabled(true)
+	if _, err := l.AllowN(ctx, "too-many", 6); err == nil {
+		t.Fatal("want ErrNExceedsMax")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.Allow(canceled, "canceled"); err == nil {
+		t.Fatal("want a canceled context error")
+	}
+	if _, err := l.Allow(canceled, "replayed"); err == nil {
+		t.Fatal("want a canceled context error")
+	}
+	if _, err := l.Allow(ctx, "replayed"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !smr.Exists(shadow.redisKey("replayed")) {
+		if time.Now().After(deadline) {
+			t.Fatal("call was not replayed to the shadow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, key := range []string{"disabled", "too-many", "canceled"} {
+		if smr.Exists(shadow.redisKey(key)) {
+			t.Errorf("call for %q was replayed although the limiter didn't execute it", key)
+		}
+	}
+}
+
+func TestShadowQueueDrops(t *testing.T) {
+	l := Limiter{shadow: &shadowReplayer{queue: make(chan replayedCall, shadowQueueSize)}}
+	for i := 0; i < 2*shadowQueueSize; i++ {
+		l.replay(context.Background(), "k", 1)
+	}
+	if n := len(l.shadow.queue); n != shadowQueueSize {
+		t.Fatalf("queued %d replays, want %d", n, shadowQueueSize)
+	}
+}
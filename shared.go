@@ -0,0 +1,62 @@
+package rate_limiter
+
+import "context"
+
+// SharedBudget lets several sub-keys consume from a single limit while
+// keeping track of how much of it each sub-key used.
+type SharedBudget struct {
+	limiter *Limiter
+	key     string
+	limit   Limit
+}
+
+// NewSharedBudget returns a SharedBudget stored under key with the given
+// limit.
+func NewSharedBudget(limiter *Limiter, key string, limit Limit) *SharedBudget {
+	return &SharedBudget{
+		limiter: limiter,
+		key:     key,
+		limit:   limit,
+	}
+}
+
+// keys returns the auxiliary Redis keys holding the budget and its usage,
+// which live in the same Redis Cluster slot.
+func (b *SharedBudget) keys() []string {
+	key := b.limiter.taggedAuxKey("shared", b.key)
+	return []string{key, key + ":usage"}
+}
+
+// Allow is a shortcut for AllowN(ctx, subKey, 1).
+func (b *SharedBudget) Allow(ctx context.Context, subKey string) (*Result, error) {
+	return b.AllowN(ctx, subKey, 1)
+}
+
+// AllowN reports whether n events may happen at time now for subKey,
+// consuming them from the shared budget and attributing them to subKey.
+func (b *SharedBudget) AllowN(ctx context.Context, subKey string, n int) (*Result, error) {
+	if err := b.limiter.validateKeys(b.key, subKey); err != nil {
+		return nil, err
+	}
+	return b.limiter.consume(ctx, call{
+		script: sharedAllowN,
+		key:    b.key,
+		keys:   b.keys(),
+		limit:  b.limit,
+		n:      n,
+		args:   []string{subKey},
+	})
+}
+
+// Usage returns the number of events each sub-key consumed from the budget
+// since it was last full.
+func (b *SharedBudget) Usage(ctx context.Context) (map[string]int64, error) {
+	rdb := b.limiter.rdb
+	return rdb.Do(ctx, rdb.B().Hgetall().Key(b.keys()[1]).Build()).AsIntMap()
+}
+
+// Reset deletes the budget and its usage.
+func (b *SharedBudget) Reset(ctx context.Context) error {
+	rdb := b.limiter.rdb
+	return rdb.Do(ctx, rdb.B().Del().Key(b.keys()...).Build()).Error()
+}
@@ -0,0 +1,39 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSharedBudget(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	b := NewSharedBudget(l, "team", PerMinute(3))
+	ctx := context.Background()
+	for _, sub := range []string{"a", "b", "a"} {
+		res, err := b.Allow(ctx, sub)
+		if err != nil || res.Allowed != 1 {
+			t.Fatalf("got %+v, %v, want the event allowed", res, err)
+		}
+	}
+	if res, err := b.Allow(ctx, "c"); err != nil || res.Allowed != 0 {
+		t.Fatalf("got %+v, %v, want the exhausted budget to deny", res, err)
+	}
+	usage, err := b.Usage(ctx)
+	if err != nil || len(usage) != 2 || usage["a"] != 2 || usage["b"] != 1 {
+		t.Fatalf("got usage %v, %v", usage, err)
+	}
+
+	// the budget doesn't share the keys of the limiter
+	if res, err := l.Allow(ctx, "{team}"); err != nil || res.Allowed != 1 {
+		t.Fatalf("got %+v, %v, want a separate key", res, err)
+	}
+	if keys, err := l.Keys(ctx); err != nil || len(keys) != 1 || keys[0] != "{team}" {
+		t.Fatalf("got keys %q, %v, want only the limiter's key", keys, err)
+	}
+	if err := b.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := b.Usage(ctx); len(usage) != 0 {
+		t.Fatalf("got usage %v after the reset", usage)
+	}
+}
@@ -0,0 +1,50 @@
+package rate_limiter
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrCrossSlot is returned when the keys of a multi-key call don't hash to
+// the same Redis Cluster slot. Keys sharing a hash tag, such as {org}team and
+// {org}user, always do.
+var ErrCrossSlot = errors.New("rate_limiter: keys hash to different slots")
+
+// checkSlots returns ErrCrossSlot unless every Redis key hashes to the same
+// slot. Keys are checked whether or not the client is a cluster client so
+// that calls behave the same against a single node.
+func checkSlots(keys []string) error {
+	for _, key := range keys[1:] {
+		if keySlot(key) != keySlot(keys[0]) {
+			return ErrCrossSlot
+		}
+	}
+	return nil
+}
+
+// keySlot returns the Redis Cluster slot of key, hashing only the hash tag
+// when the key has one.
+func keySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % 16384
+}
+
+// crc16 is the CRC-16/XMODEM checksum Redis Cluster uses for slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
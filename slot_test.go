@@ -0,0 +1,37 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	for key, want := range map[string]uint16{
+		"123456789":            12739,
+		"foo":                  12182,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"foo{}{bar}":           8363,
+		"foo{{bar}}zap":        4015,
+		"foo{bar}{zap}":        5061,
+	} {
+		if got := keySlot(key); got != want {
+			t.Errorf("slot of %q: got %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestCrossSlot(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	ctx := context.Background()
+	if _, err := l.AllowHierarchy(ctx, []string{"org", "user"}, 1); !errors.Is(err, ErrCrossSlot) {
+		t.Fatalf("AllowHierarchy: got %v, want ErrCrossSlot", err)
+	}
+	if err := l.TransferQuota(ctx, "from", "to"); !errors.Is(err, ErrCrossSlot) {
+		t.Fatalf("TransferQuota: got %v, want ErrCrossSlot", err)
+	}
+	if _, err := l.AllowHierarchy(ctx, []string{"{o}org", "{o}user"}, 1); err != nil {
+		t.Fatalf("AllowHierarchy with a hash tag: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package rate_limiter
+
+import "time"
+
+// ResultSnapshot is a Result with its durations converted to absolute times,
+// so that it stays correct when cached or sent to another process.
+type ResultSnapshot struct {
+	// Time is when the snapshot was taken.
+	Time      time.Time
+	Limit     Limit
+	Allowed   int
+	Remaining int
+	// RetryAt is when the next request will be permitted, or the zero time
+	// if RetryAfter didn't apply.
+	RetryAt time.Time
+	// ResetAt is when the key returns to its initial state, or the zero
+	// time if ResetAfter didn't apply.
+	ResetAt time.Time
+	Reason  Reason
+}
+
+// AtTime returns a snapshot of r taken at now, which should be the time r was
+// obtained.
+func (r *Result) AtTime(now time.Time) ResultSnapshot {
+	s := ResultSnapshot{
+		Time:      now,
+		Limit:     r.Limit,
+		Allowed:   r.Allowed,
+		Remaining: r.Remaining,
+		Reason:    r.Reason,
+	}
+	if r.RetryAfter > 0 {
+		s.RetryAt = now.Add(r.RetryAfter)
+	}
+	if r.ResetAfter > 0 {
+		s.ResetAt = now.Add(r.ResetAfter)
+	}
+	return s
+}
+
+// Result converts s back to a Result relative to now. Durations that have
+// already elapsed, or that didn't apply, are reported as -1.
+func (s ResultSnapshot) Result(now time.Time) *Result {
+	res := &Result{
+		Limit:      s.Limit,
+		Allowed:    s.Allowed,
+		Remaining:  s.Remaining,
+		RetryAfter: -1,
+		ResetAfter: -1,
+		Reason:     s.Reason,
+	}
+	if d := s.RetryAt.Sub(now); !s.RetryAt.IsZero() && d > 0 {
+		res.RetryAfter = d
+	}
+	if d := s.ResetAt.Sub(now); !s.ResetAt.IsZero() && d > 0 {
+		res.ResetAfter = d
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package rate_limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultSnapshot(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &Result{Limit: PerMinute(10), Remaining: 0, RetryAfter: 6 * time.Second, ResetAfter: time.Minute, Reason: ReasonLimitExceeded}
+	s := res.AtTime(now)
+	if !s.RetryAt.Equal(now.Add(6*time.Second)) || !s.ResetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("got snapshot %+v, want absolute times", s)
+	}
+
+	got := s.Result(now.Add(2 * time.Second))
+	if got.RetryAfter != 4*time.Second || got.ResetAfter != 58*time.Second {
+		t.Fatalf("got %v and %v two seconds later, want 4s and 58s", got.RetryAfter, got.ResetAfter)
+	}
+	if got.Limit != res.Limit || got.Reason != res.Reason {
+		t.Fatalf("got %+v, want the snapshot's limit and reason", got)
+	}
+	got = s.Result(now.Add(10 * time.Second))
+	if got.RetryAfter != -1 || got.ResetAfter != 50*time.Second {
+		t.Fatalf("got %v and %v after the retry time, want -1 and 50s", got.RetryAfter, got.ResetAfter)
+	}
+
+	s = (&Result{Allowed: 1, RetryAfter: -1}).AtTime(now)
+	if !s.RetryAt.IsZero() || s.Result(now).RetryAfter != -1 {
+		t.Fatalf("got snapshot %+v, want no retry time", s)
+	}
+}
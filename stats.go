@@ -0,0 +1,156 @@
+package rate_limiter
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// Stats holds counters describing how the limiter has been talking to Redis.
+type Stats struct {
+	// ScriptReloads is the number of times a script or, in function mode,
+	// the function library had to be sent to Redis again because it wasn't
+	// cached, which usually means Redis restarted or failed over. Loading
+	// them the first time isn't counted.
+	ScriptReloads int64
+
+	// AvgLatency and P99Latency are the mean and 99th percentile latency of
+	// the most recent script calls, including pipelines of several keys.
+	// They are zero until a call completed.
+	AvgLatency time.Duration
+	P99Latency time.Duration
+
+	// Keys is the number of keys stored under the limiter's prefix, and
+	// MemoryBytes an estimate of the memory they use, extrapolated from a
+	// sample of keys. They are only set by StartExporter.
+	Keys        int64
+	MemoryBytes int64
+}
+
+// latencySamples is how many of the most recent script call latencies are
+// kept.
+const latencySamples = 256
+
+type stats struct {
+	scriptReloads atomic.Int64
+	// loaded holds the SHA1 of the scripts and the name of the function
+	// library seen loaded, so that only reloading them counts as a reload.
+	loaded sync.Map
+
+	// latencies is a ring of the most recent latencies, written without
+	// locking. calls is the number of latencies ever recorded.
+	latencies [latencySamples]atomic.Int64
+	calls     atomic.Uint64
+}
+
+// markLoaded records that the script or library called name is loaded.
+func (s *stats) markLoaded(name string) {
+	if _, ok := s.loaded.Load(name); !ok {
+		s.loaded.Store(name, struct{}{})
+	}
+}
+
+// reload counts loading name again as a reload, unless it is the first load.
+func (s *stats) reload(name string) {
+	if _, ok := s.loaded.Load(name); ok {
+		s.scriptReloads.Add(1)
+	}
+}
+
+// observe records the latency of a call that started at start.
+func (s *stats) observe(start time.Time) {
+	i := s.calls.Add(1) - 1
+	s.latencies[i%latencySamples].Store(int64(time.Since(start)))
+}
+
+// Stats returns a snapshot of the limiter's counters.
+func (l Limiter) Stats() Stats {
+	st := Stats{
+		ScriptReloads: l.stats.scriptReloads.Load(),
+	}
+	n := min(l.stats.calls.Load(), latencySamples)
+	if n == 0 {
+		return st
+	}
+	samples := make([]time.Duration, n)
+	var sum time.Duration
+	for i := range samples {
+		samples[i] = time.Duration(l.stats.latencies[i].Load())
+		sum += samples[i]
+	}
+	slices.Sort(samples)
+	st.AvgLatency = sum / time.Duration(n)
+	st.P99Latency = samples[(len(samples)*99-1)/100]
+	return st
+}
+
+// memorySamples is how many keys StartExporter reads the memory usage of.
+const memorySamples = 100
+
+// StartExporter calls emit with the limiter's stats every interval until ctx
+// is done, adding the number of keys under the prefix and an estimate of the
+// memory they use. Keys are counted with SCAN as Count does, so the exporter
+// should run on a single instance with an interval of minutes rather than
+// seconds. Intervals where Redis can't be reached are skipped. It panics if
+// interval is not positive.
+func (l Limiter) StartExporter(ctx context.Context, interval time.Duration, emit func(Stats)) {
+	if interval <= 0 {
+		panic("rate_limiter: exporter interval must be positive")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			st := l.Stats()
+			var err error
+			if st.Keys, st.MemoryBytes, err = l.keyUsage(ctx); err != nil {
+				continue
+			}
+			emit(st)
+		}
+	}()
+}
+
+// keyUsage returns the number of keys under the prefix and an estimate of
+// their memory usage in bytes.
+func (l Limiter) keyUsage(ctx context.Context) (int64, int64, error) {
+	var count int64
+	var sample []string
+	err := l.scan(ctx, "*", func(keys []string) error {
+		count += int64(len(keys))
+		sample = append(sample, keys[:min(len(keys), memorySamples-len(sample))]...)
+		return nil
+	})
+	if err != nil || len(sample) == 0 {
+		return count, 0, err
+	}
+	cmds := make(rueidis.Commands, len(sample))
+	for i, k := range sample {
+		cmds[i] = l.rdb.B().MemoryUsage().Key(k).Build()
+	}
+	var used, sampled int64
+	for _, resp := range l.rdb.DoMulti(ctx, cmds...) {
+		n, err := resp.AsInt64()
+		if rueidis.IsRedisNil(err) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		used += n
+		sampled++
+	}
+	if sampled == 0 {
+		return count, 0, nil
+	}
+	return count, used * count / sampled, nil
+}
@@ -0,0 +1,69 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	l := NewLimiter(nil)
+	if st := l.Stats(); st.AvgLatency != 0 || st.P99Latency != 0 {
+		t.Fatalf("got %+v before any call, want zero latencies", st)
+	}
+	// calls of 1ms with one in 50 taking 100ms, after older calls that are
+	// pushed out of the ring
+	for i := 0; i < latencySamples; i++ {
+		l.stats.observe(time.Now().Add(-time.Second))
+	}
+	for i := 0; i < latencySamples; i++ {
+		d := time.Millisecond
+		if i%50 == 49 {
+			d = 100 * time.Millisecond
+		}
+		l.stats.observe(time.Now().Add(-d))
+	}
+	st := l.Stats()
+	if st.AvgLatency < 2800*time.Microsecond || st.AvgLatency > 3500*time.Microsecond {
+		t.Errorf("got average latency %v, want about 2.9ms", st.AvgLatency)
+	}
+	if st.P99Latency < 100*time.Millisecond || st.P99Latency > 110*time.Millisecond {
+		t.Errorf("got p99 latency %v, want about 100ms", st.P99Latency)
+	}
+}
+
+func TestStartExporter(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	emitted := make(chan Stats)
+	l.StartExporter(ctx, 10*time.Millisecond, func(st Stats) {
+		emitted <- st
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case st := <-emitted:
+			if st.Keys != 3 || st.MemoryBytes <= 0 {
+				t.Fatalf("got %+v, want 3 keys using some memory", st)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("exporter didn't emit")
+		}
+	}
+
+	cancel()
+	// an emission racing with the cancellation may still arrive
+	select {
+	case <-emitted:
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case st := <-emitted:
+		t.Fatalf("got %+v after the context was cancelled", st)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,42 @@
+package rate_limiter
+
+// WithThresholdCallback calls fn when a consuming call takes the fraction of a
+// key's capacity that remains from at least fraction to below it, for
+// example 0.2 to be warned once 80% of the limit is used. fn is called once
+// per crossing, by the call that crossed, on the calling goroutine. It panics
+// if fraction is not between 0 and 1.
+func WithThresholdCallback(fraction float64, fn func(key string, res *Result)) LimiterOption {
+	if fraction <= 0 || fraction > 1 {
+		panic("rate_limiter: threshold fraction must be in (0, 1]")
+	}
+	return func(l *Limiter) {
+		l.threshold = fraction
+		l.thresholdCallback = fn
+	}
+}
+
+// checkSoftLimit sets res.OverSoftLimit if more events than the soft limit
+// are used.
+func (l Limiter) checkSoftLimit(c call, res *Result) {
+	if res == nil || c.limit.SoftLimit <= 0 {
+		return
+	}
+	capacity := KeyPolicy{Limit: c.limit, Algorithm: c.algorithm}.capacity()
+	res.OverSoftLimit = capacity-res.Remaining > c.limit.SoftLimit
+}
+
+// checkThreshold calls the threshold callback if res crossed the threshold.
+func (l Limiter) checkThreshold(c call, res *Result) {
+	if l.thresholdCallback == nil || res == nil || res.Allowed == 0 {
+		return
+	}
+	capacity := float64(KeyPolicy{Limit: c.limit, Algorithm: c.algorithm}.capacity())
+	if capacity <= 0 {
+		return
+	}
+	before := float64(res.PreviousRemaining) / capacity
+	after := float64(res.Remaining) / capacity
+	if before >= l.threshold && after < l.threshold {
+		l.thresholdCallback(c.key, res)
+	}
+}
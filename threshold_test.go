@@ -0,0 +1,37 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThresholdCallback(t *testing.T) {
+	var crossed []int
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(10)),
+		WithThresholdCallback(0.2, func(key string, res *Result) {
+			crossed = append(crossed, res.Remaining)
+		}))
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	for i := 0; i < 12; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(crossed) != 1 || crossed[0] != 1 {
+		t.Fatalf("got callbacks with remaining %v, want a single one with 1 remaining", crossed)
+	}
+
+	// refilling above the threshold lets the next window cross it again
+	mr.SetTime(now.Add(time.Minute))
+	for i := 0; i < 9; i++ {
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(crossed) != 2 {
+		t.Fatalf("got %d callbacks, want another one for the new window", len(crossed))
+	}
+}
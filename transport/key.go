@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the IP addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// maxCachedHosts is how many resolved hosts KeyByResolvedIP keeps before
+// dropping expired entries.
+const maxCachedHosts = 1024
+
+// KeyByResolvedIP returns a key function using the IP address the request's
+// host resolves to instead of its name, so that hosts served by the same
+// backend share a limit. Addresses are resolved with resolver and cached for
+// cacheFor. Hosts that are IP addresses are used as they are, and hosts that
+// can't be resolved fall back to the host name. The base transport resolves
+// the host on its own, so it may connect to another address of the host
+// than the one used as the key.
+func KeyByResolvedIP(resolver Resolver, cacheFor time.Duration) func(*http.Request) string {
+	c := &resolveCache{
+		resolver: resolver,
+		cacheFor: cacheFor,
+		entries:  make(map[string]resolved),
+	}
+	return func(r *http.Request) string {
+		return c.lookup(r.Context(), r.URL.Hostname())
+	}
+}
+
+type resolved struct {
+	ip      string
+	expires time.Time
+}
+
+type resolveCache struct {
+	resolver Resolver
+	cacheFor time.Duration
+
+	mu      sync.Mutex
+	entries map[string]resolved
+}
+
+func (c *resolveCache) lookup(ctx context.Context, host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.ip
+	}
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return host
+	}
+	ip := addrs[0].IP.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedHosts {
+		for h, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, h)
+			}
+		}
+	}
+	c.entries[host] = resolved{ip: ip, expires: now.Add(c.cacheFor)}
+	return ip
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	rl "github.com/jsjain/go-rate-limiter"
+)
+
+// stubResolver resolves hosts from a map and counts lookups.
+type stubResolver struct {
+	addrs   map[string]string
+	lookups int
+}
+
+func (r *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	ip, ok := r.addrs[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestKeyByResolvedIP(t *testing.T) {
+	resolver := &stubResolver{addrs: map[string]string{
+		"a.example": "192.0.2.1",
+		"b.example": "192.0.2.1",
+		"c.example": "192.0.2.2",
+	}}
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	client := &http.Client{Transport: New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(1))),
+		WithBase(base), WithKeyFunc(KeyByResolvedIP(resolver, time.Minute)))}
+	get := func(url string) error {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get("http://a.example/"); err != nil {
+		t.Fatal(err)
+	}
+	var denied *DeniedError
+	if err := get("http://b.example/"); !errors.As(err, &denied) {
+		t.Fatalf("got %v, want hosts with the same address to share a limit", err)
+	}
+	if err := get("http://c.example/"); err != nil {
+		t.Fatalf("got %v, want another address limited separately", err)
+	}
+	if err := get("http://a.example/"); !errors.As(err, &denied) {
+		t.Fatalf("got %v, want the cached address denied", err)
+	}
+	if resolver.lookups != 3 {
+		t.Fatalf("resolved %d times, want each host resolved once", resolver.lookups)
+	}
+
+	// hosts that can't be resolved fall back to their name
+	key := KeyByResolvedIP(resolver, time.Minute)
+	r, _ := http.NewRequest(http.MethodGet, "http://unknown.example/", nil)
+	if got := key(r); got != "unknown.example" {
+		t.Fatalf("got key %q, want the host name", got)
+	}
+}
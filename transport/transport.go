@@ -0,0 +1,101 @@
+// Package transport provides an http.RoundTripper that rate limits outgoing
+// requests with a rate_limiter.Limiter.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	rl "github.com/jsjain/go-rate-limiter"
+)
+
+// DeniedError is returned by Transport for requests that were rate limited.
+type DeniedError struct {
+	Result *rl.Result
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("transport: rate limited, retry after %s", e.Result.RetryAfter)
+}
+
+// Transport is an http.RoundTripper that consumes an event for every request
+// before passing it on to its base transport.
+type Transport struct {
+	limiter *rl.Limiter
+	base    http.RoundTripper
+	keyFunc func(*http.Request) string
+	wait    bool
+}
+
+// Option configures a Transport.
+type Option func(*Transport)
+
+// WithBase sets the transport performing the requests. The default is
+// http.DefaultTransport.
+func WithBase(base http.RoundTripper) Option {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// WithKeyFunc sets the function deriving the rate limiting key from a
+// request. The default uses the request's host. See KeyByResolvedIP to key by
+// the host's address instead.
+func WithKeyFunc(fn func(*http.Request) string) Option {
+	return func(t *Transport) {
+		t.keyFunc = fn
+	}
+}
+
+// WithWait makes the transport wait until a request is allowed, or its
+// context is done, instead of failing it with a DeniedError.
+func WithWait() Option {
+	return func(t *Transport) {
+		t.wait = true
+	}
+}
+
+// New returns a Transport limiting requests with limiter.
+func New(limiter *rl.Limiter, opts ...Option) *Transport {
+	t := &Transport{
+		limiter: limiter,
+		base:    http.DefaultTransport,
+		keyFunc: host,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.allow(r); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(r)
+}
+
+func (t *Transport) allow(r *http.Request) error {
+	key := t.keyFunc(r)
+	if t.wait {
+		_, err := t.limiter.Wait(r.Context(), key)
+		return err
+	}
+	res, err := t.limiter.Allow(r.Context(), key)
+	if err != nil && !errors.Is(err, rl.ErrRateLimited) {
+		return err
+	}
+	if res.Allowed == 0 {
+		return &DeniedError{Result: res}
+	}
+	return nil
+}
+
+func host(r *http.Request) string {
+	return r.URL.Host
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rl "github.com/jsjain/go-rate-limiter"
+	"github.com/redis/rueidis"
+)
+
+func newTestLimiter(t *testing.T, opts ...rl.LimiterOption) *rl.Limiter {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:  []string{mr.Addr()},
+		DisableCache: true,
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(rdb.Close)
+	return rl.NewLimiter(rdb, opts...)
+}
+
+// newServer returns a server counting the requests it received.
+func newServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestTransportDenies(t *testing.T) {
+	srv, hits := newServer(t)
+	client := &http.Client{Transport: New(newTestLimiter(t, rl.WithRateLimit(rl.PerMinute(1))))}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, err = client.Get(srv.URL)
+	var denied *DeniedError
+	if !errors.As(err, &denied) || denied.Result.Allowed != 0 {
+		t.Fatalf("got %v, want a DeniedError", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("server got %d requests, want the denied one held back", n)
+	}
+}
+
+func TestTransportWaits(t *testing.T) {
+	srv, hits := newServer(t)
+	limit := rl.Limit{Rate: 10, Burst: 1, Period: time.Second}
+	client := &http.Client{Transport: New(newTestLimiter(t, rl.WithRateLimit(limit)), WithWait())}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// the first request uses the burst, the others are 100ms apart
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("4 requests took %v, want them paced at 10/s", d)
+	}
+	if n := hits.Load(); n != 4 {
+		t.Fatalf("server got %d requests, want 4", n)
+	}
+}
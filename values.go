@@ -0,0 +1,35 @@
+package rate_limiter
+
+import "sync"
+
+// values builds the arguments passed to a script. Builders are pooled since
+// every call needs one; the arguments are copied into the command, so a
+// builder can be released as soon as the script returns.
+type values []string
+
+var valuesPool = sync.Pool{
+	New: func() any {
+		v := make(values, 0, 8)
+		return &v
+	},
+}
+
+// limitValues returns a builder holding the script arguments for limit and n.
+func (l Limiter) limitValues(limit Limit, n int) *values {
+	v := valuesPool.Get().(*values)
+	*v = l.appendLimitArgs((*v)[:0], limit, n)
+	return v
+}
+
+// add appends args to v.
+func (v *values) add(args ...string) *values {
+	*v = append(*v, args...)
+	return v
+}
+
+// release returns v to the pool. v must not be used afterwards.
+func (v *values) release() {
+	clear(*v)
+	*v = (*v)[:0]
+	valuesPool.Put(v)
+}
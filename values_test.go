@@ -0,0 +1,91 @@
+package rate_limiter
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLimitValues(t *testing.T) {
+	l := NewLimiter(nil, WithTTLMargin(time.Minute))
+	for _, tc := range []struct {
+		limit Limit
+		want  []string
+	}{
+		{PerSecond(10), []string{"10", "10", "1000000000", "2", "60000", "extra"}},
+		{PerMinute(1), []string{"1", "1", "60000000000", "2", "60000", "extra"}},
+		{Limit{Rate: 3, Burst: 7, Period: time.Hour, ExtraTTL: time.Hour}, []string{"7", "3", "3600000000000", "2", "3600000", "extra"}},
+	} {
+		// the second call uses the cached limit
+		for i := 0; i < 2; i++ {
+			v := l.limitValues(tc.limit, 2).add("extra")
+			if !slices.Equal(*v, tc.want) {
+				t.Errorf("limit %v, call %d: got %q, want %q", tc.limit, i, *v, tc.want)
+			}
+			v.release()
+		}
+	}
+}
+
+func Benchmark_AllowN(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []LimiterOption
+	}{
+		{"GCRA", nil},
+		{"ResultPool", []LimiterOption{WithResultPool()}},
+		{"SlidingWindow", []LimiterOption{WithAlgorithm(AlgoSlidingWindow)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			l, _ := newTestLimiter(b, append(bc.opts, WithRateLimit(PerSecond(1000000)))...)
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, err := l.AllowN(ctx, "k", 1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				res.Release()
+			}
+		})
+	}
+}
+
+func Benchmark_limitValues(b *testing.B) {
+	l := NewLimiter(nil)
+	limit := PerSecond(10)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.limitValues(limit, 1).release()
+	}
+}
+
+func TestPeriodEncoding(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 1, Period: 333 * time.Millisecond}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		opts   []LimiterOption
+		period time.Duration
+	}{
+		{"integer nanoseconds", nil, 333 * time.Millisecond},
+		{"legacy", []LimiterOption{WithLegacyPeriodEncoding()}, 330 * time.Millisecond},
+	} {
+		l, mr := newTestLimiter(t, append(tc.opts, WithRateLimit(limit))...)
+		ctx := context.Background()
+		mr.SetTime(start)
+		if _, err := l.Allow(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+		mr.SetTime(start.Add(tc.period - time.Millisecond))
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != 0 {
+			t.Fatalf("%s: got %+v, %v just before the period, want a denial", tc.name, res, err)
+		}
+		mr.SetTime(start.Add(tc.period))
+		if res, err := l.Allow(ctx, "k"); err != nil || res.Allowed != 1 {
+			t.Fatalf("%s: got %+v, %v after the period, want the event allowed", tc.name, res, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNExceedsBurst is returned by WaitN when n is larger than the
+	// burst of the key's limit (its rate for window algorithms) and so can
+	// never be allowed.
+	ErrNExceedsBurst = errors.New("rate_limiter: n exceeds the limit's burst")
+	// ErrNoRetryTime is returned by WaitN when a denial has no retry time
+	// to wait for, such as when the limiter fails closed.
+	ErrNoRetryTime = errors.New("rate_limiter: denied without a retry time")
+)
+
+// WaitInfo describes a call to WaitN.
+type WaitInfo struct {
+	// Position is the number of goroutines in this process that were
+	// waiting on the key, including this one, when the wait began. It is 0
+	// if the events were allowed without waiting.
+	Position int
+
+	// ETA is the estimated time the wait would take when it began, based
+	// on RetryAfter and the local waiters ahead of this one.
+	ETA time.Duration
+
+	// Result is the result of the call that allowed the events.
+	Result *Result
+}
+
+type waiters struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (w *waiters) add(key string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.counts[key]++
+	return w.counts[key]
+}
+
+func (w *waiters) done(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.counts[key]--; w.counts[key] <= 0 {
+		delete(w.counts, key)
+	}
+}
+
+// Wait is a shortcut for WaitN(ctx, key, n) where n is the default set by
+// WithDefaultN.
+func (l Limiter) Wait(ctx context.Context, key string) (WaitInfo, error) {
+	return l.WaitN(ctx, key, l.defaultN)
+}
+
+// WaitN blocks until n events are allowed for key or ctx is done.
+func (l Limiter) WaitN(ctx context.Context, key string, n int) (WaitInfo, error) {
+	var info WaitInfo
+	if err := l.validateKeys(key); err != nil {
+		return info, err
+	}
+	if n > l.policyFor(key).capacity() {
+		return info, ErrNExceedsBurst
+	}
+	for {
+		res, err := l.allowN(ctx, key, n)
+		if err != nil {
+			return info, err
+		}
+		if res.Allowed > 0 || n == 0 {
+			info.Result = res
+			return info, nil
+		}
+		if res.RetryAfter <= 0 {
+			return info, ErrNoRetryTime
+		}
+		if info.Position == 0 {
+			info.Position = l.waiters.add(key)
+			defer l.waiters.done(key)
+			info.ETA = res.RetryAfter + time.Duration(info.Position-1)*interval(res.Limit)*time.Duration(n)
+		}
+
+		t := time.NewTimer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return info, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// ScheduleAt returns the earliest time at which n events would be allowed for
+// key given its current state, without consuming any events. The time may
+// be brought forward by calls to Refund or pushed back by other callers, see
+// ReserveAt to hold it. It returns ErrNExceedsBurst if n is larger than the
+// key's burst.
+func (l Limiter) ScheduleAt(ctx context.Context, key string, n int) (time.Time, error) {
+	return l.scheduleAt(ctx, key, n, false)
+}
+
+// ReserveAt is like ScheduleAt but also consumes the n events, so that they
+// are held for the caller at the returned time. Until then other callers are
+// denied as if the events had been consumed. The caller performs its work at
+// that time without calling AllowN again, or gives the events back with
+// Refund if it no longer needs them.
+func (l Limiter) ReserveAt(ctx context.Context, key string, n int) (time.Time, error) {
+	return l.scheduleAt(ctx, key, n, true)
+}
+
+func (l Limiter) scheduleAt(ctx context.Context, key string, n int, reserve bool) (time.Time, error) {
+	if err := l.validateKeys(key); err != nil {
+		return time.Time{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	limit, err := l.gcraLimitFor(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n > limit.Burst || limit.Burst == 0 {
+		return time.Time{}, ErrNExceedsBurst
+	}
+	flag := "0"
+	if reserve {
+		flag = "1"
+	}
+	v := l.limitValues(limit, n).add(flag)
+	defer v.release()
+	raw, err := scriptResult(l.eval(ctx, scheduleAt, []string{l.redisKey(key)}, *v))
+	if err != nil {
+		return time.Time{}, err
+	}
+	res, err := decodeResult(limit, raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return l.now().Add(max(res.RetryAfter, 0)), nil
+}
+
+// interval returns the time it takes limit to refill a single event.
+func interval(limit Limit) time.Duration {
+	if limit.Rate <= 0 {
+		return 0
+	}
+	return limit.Period / time.Duration(limit.Rate)
+}
@@ -0,0 +1,96 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitPositions(t *testing.T) {
+	l, _ := newTestLimiter(t, WithRateLimit(Limit{Rate: 10, Burst: 1, Period: time.Second}))
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	const waiters = 4
+	infos := make(chan WaitInfo, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			info, err := l.Wait(ctx, "k")
+			if err != nil {
+				t.Error(err)
+			}
+			infos <- info
+		}()
+		// start the waiters one after the other so that their positions
+		// follow the order they started in
+		for waiting(l, "k") != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	etas := make(map[int]time.Duration)
+	for i := 0; i < waiters; i++ {
+		info := <-infos
+		if info.Result == nil || info.Result.Allowed != 1 {
+			t.Fatalf("got %+v, want the wait to end with the event allowed", info)
+		}
+		etas[info.Position] = info.ETA
+	}
+	if len(etas) != waiters {
+		t.Fatalf("got ETAs by position %v, want positions 1 to %d", etas, waiters)
+	}
+	for pos := 2; pos <= waiters; pos++ {
+		if etas[pos] <= etas[pos-1] {
+			t.Fatalf("position %d has ETA %v, not after position %d's %v", pos, etas[pos], pos-1, etas[pos-1])
+		}
+	}
+	if waiting(l, "k") != 0 {
+		t.Fatal("waiters are still counted after the waits ended")
+	}
+
+	info, err := l.Wait(ctx, "idle")
+	if err != nil || info.Position != 0 || info.ETA != 0 {
+		t.Fatalf("got %+v, %v, want no wait for an idle key", info, err)
+	}
+}
+
+func waiting(l *Limiter, key string) int {
+	l.waiters.mu.Lock()
+	defer l.waiters.mu.Unlock()
+	return l.waiters.counts[key]
+}
+
+func TestScheduleAt(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, mr := newTestLimiter(t, WithRateLimit(PerMinute(2)), WithClock(func() time.Time { return now }))
+	mr.SetTime(now)
+	ctx := context.Background()
+	schedule := func(reserve bool, want time.Time) {
+		t.Helper()
+		fn := l.ScheduleAt
+		if reserve {
+			fn = l.ReserveAt
+		}
+		at, err := fn(ctx, "k", 1)
+		if err != nil || !at.Equal(want) {
+			t.Fatalf("reserve %v: got %v, %v, want %v", reserve, at, err, want)
+		}
+	}
+	schedule(false, now)
+	if _, err := l.AllowN(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	res, err := l.Allow(ctx, "k")
+	if err != nil || res.RetryAfter != 30*time.Second {
+		t.Fatalf("got %+v, %v, want a retry after 30s", res, err)
+	}
+	schedule(false, now.Add(res.RetryAfter))
+	schedule(true, now.Add(res.RetryAfter))
+	// the reserved event pushes the next one back
+	schedule(false, now.Add(time.Minute))
+
+	if _, err := l.ScheduleAt(ctx, "k", 3); !errors.Is(err, ErrNExceedsBurst) {
+		t.Fatalf("got %v, want ErrNExceedsBurst", err)
+	}
+}